@@ -2,7 +2,9 @@ package bench
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,9 +14,11 @@ import (
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -30,14 +34,19 @@ const TorbAppHost = "torb.example.com"
 var (
 	RedirectAttemptedError = fmt.Errorf("redirect attempted")
 	RequestTimeoutError    = fmt.Errorf("リクエストがタイムアウトしました")
+	ConnectTimeoutError    = fmt.Errorf("接続がタイムアウトしました")
 	UserAgent              = "isucon8q-benchmarker"
 	GetTimeout             = parameter.GetTimeout
 	PostTimeout            = parameter.PostTimeout
 	DeleteTimeout          = parameter.DeleteTimeout
 	InitializeTimeout      = parameter.InitializeTimeout
+	ConnectTimeout         = parameter.ConnectTimeout
 	SlowThreshold          = parameter.SlowThreshold
 	MaxCheckerRequest      = parameter.MaxCheckerRequest
 	DebugMode              = false
+
+	TargetScheme    = "http"        // "https" to benchmark a TLS endpoint
+	TLSClientConfig = &tls.Config{} // set InsecureSkipVerify for self-signed certificates
 )
 
 var (
@@ -52,6 +61,11 @@ var (
 	requestCountMtx sync.Mutex
 
 	checkerRequestCounter int32 = 0
+
+	benchRequestIDPrefix        = strconv.FormatInt(time.Now().UnixNano(), 36)
+	benchRequestCounter  uint64 = 0
+
+	userAgentCounter uint32 = 0
 )
 
 func SetTargetHosts(target []string) {
@@ -119,10 +133,26 @@ var (
 	transport = &CheckerTransport{
 		&http.Transport{
 			MaxIdleConnsPerHost: 65536,
+			TLSClientConfig:     TLSClientConfig,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				d := net.Dialer{Timeout: ConnectTimeout}
+				return d.DialContext(ctx, network, addr)
+			},
 		},
 	}
 )
 
+// keep-alive がどれくらい効いているかを見るためにコネクションの新規/再利用を数える
+var connTrace = &httptrace.ClientTrace{
+	GotConn: func(info httptrace.GotConnInfo) {
+		if info.Reused {
+			counter.IncKey("conn-reused")
+		} else {
+			counter.IncKey("conn-new")
+		}
+	},
+}
+
 func updateLastSlowPath(path string) {
 	checkerMtx.Lock()
 	defer checkerMtx.Unlock()
@@ -154,15 +184,28 @@ func fatalErrorf(format string, a ...interface{}) error {
 	return &fatalError{fmt.Sprintf(format, a...)}
 }
 
+// 5xx response from the webapp
+type serverError struct {
+	status string
+}
+
+func (e *serverError) Error() string {
+	return fmt.Sprint("サーバエラーが発生しました。", e.status)
+}
+
 type CheckerError struct {
-	t      time.Time
-	err    error
-	method string
-	path   string
-	query  string
+	t         time.Time
+	err       error
+	method    string
+	path      string
+	query     string
+	requestID string // X-Bench-Request-ID
 }
 
 func (e *CheckerError) Error() string {
+	if e.requestID != "" {
+		return fmt.Sprintf("%v %v (%v %v %v) request_id=%v", e.t, e.err, e.method, e.path, e.query, e.requestID)
+	}
 	return fmt.Sprintf("%v %v (%v %v %v)", e.t, e.err, e.method, e.path, e.query)
 }
 
@@ -172,7 +215,16 @@ func (e *CheckerError) IsFatal() bool {
 }
 
 func (e *CheckerError) IsTimeout() bool {
-	return e.err == RequestTimeoutError
+	return e.err == RequestTimeoutError || e.err == ConnectTimeoutError
+}
+
+func (e *CheckerError) IsConnectTimeout() bool {
+	return e.err == ConnectTimeoutError
+}
+
+func (e *CheckerError) IsServerError() bool {
+	_, ok := e.err.(*serverError)
+	return ok
 }
 
 func IsFatal(err error) bool {
@@ -196,6 +248,13 @@ func IsCheckerTimeout(err error) bool {
 	return false
 }
 
+func IsCheckerServerError(err error) bool {
+	if cerr, ok := err.(*CheckerError); ok {
+		return cerr.IsServerError()
+	}
+	return false
+}
+
 func appendError(err *CheckerError) {
 	checkerMtx.Lock()
 	if !checkerErrorGuard {
@@ -238,6 +297,7 @@ type Checker struct {
 
 	chRequestToken chan int
 	debugHeaders   map[string]string
+	userAgent      string
 }
 
 type CheckAction struct {
@@ -254,12 +314,23 @@ type CheckAction struct {
 	ExpectedLocation   *regexp.Regexp
 	ExpectedHeaders    map[string]string
 	Description        string
-	CheckFunc          func(*http.Response, *bytes.Buffer) error
+	CheckFunc          func(*http.Response, *bytes.Buffer) error // the body is pooled and reused after CheckFunc returns, do not retain it
 
+	// If set, the body is not buffered and handed to StreamCheckFunc as it is received instead of CheckFunc.
+	// Use it for large responses like reports. EnableCache and EnableGzip are ignored.
+	StreamCheckFunc func(*http.Response, io.Reader) error
+
+	StrictJSON          bool // disallow unknown fields and zero values of required fields in the JSON response
 	EnableCache         bool
+	EnableGzip          bool // send Accept-Encoding: gzip and decompress the body by itself
 	DisableSlowChecking bool
 
 	Timeout time.Duration
+
+	// Retries only on network errors, never on unexpected status codes or CheckFunc failures.
+	// Do not set it for requests which are not idempotent.
+	MaxRetries   int
+	RetryBackoff time.Duration // doubled on each retry
 }
 
 func NewChecker() *Checker {
@@ -280,6 +351,7 @@ func NewChecker() *Checker {
 
 	c.Cache = urlcache.NewCacheStore()
 	c.debugHeaders = map[string]string{}
+	c.userAgent = nextUserAgent()
 	c.chRequestToken = make(chan int, MaxCheckerRequest)
 	for i := 1; i <= MaxCheckerRequest; i++ {
 		c.chRequestToken <- i
@@ -288,6 +360,16 @@ func NewChecker() *Checker {
 	return c
 }
 
+// Each checker is a client with a fixed User-Agent, rotating parameter.UserAgents over checkers
+func nextUserAgent() string {
+	n := len(parameter.UserAgents)
+	if n == 0 {
+		return UserAgent
+	}
+	i := atomic.AddUint32(&userAgentCounter, 1)
+	return parameter.UserAgents[int(i)%n]
+}
+
 func (c *Checker) ResetCookie() {
 	jar, err := cookiejar.New(&cookiejar.Options{})
 	if err != nil {
@@ -296,6 +378,28 @@ func (c *Checker) ResetCookie() {
 	c.Client.Jar = jar
 }
 
+func (c *Checker) cookieURL() *url.URL {
+	return &url.URL{Scheme: TargetScheme, Host: TorbAppHost, Path: "/"}
+}
+
+// Returns cookies which will be sent to the webapp
+func (c *Checker) Cookies() []*http.Cookie {
+	return c.Client.Jar.Cookies(c.cookieURL())
+}
+
+func (c *Checker) SetCookies(cookies []*http.Cookie) {
+	c.Client.Jar.SetCookies(c.cookieURL(), cookies)
+}
+
+// Expires all cookies as if the session had timed out on the browser
+func (c *Checker) ExpireCookies() {
+	var expired []*http.Cookie
+	for _, cookie := range c.Cookies() {
+		expired = append(expired, &http.Cookie{Name: cookie.Name, Path: "/", MaxAge: -1})
+	}
+	c.SetCookies(expired)
+}
+
 func (c *Checker) OnError(a *CheckAction, req *http.Request, err error) error {
 	// OnFailが1つのエラーに対して2回以上呼ばれた時の対策
 	if _, ok := err.(*CheckerError); ok {
@@ -304,11 +408,12 @@ func (c *Checker) OnError(a *CheckAction, req *http.Request, err error) error {
 
 	var cerr *CheckerError
 	if req == nil {
-		cerr = &CheckerError{time.Now(), err, a.Method, a.Path, ""}
+		cerr = &CheckerError{time.Now(), err, a.Method, a.Path, "", ""}
 	} else {
-		cerr = &CheckerError{time.Now(), err, req.Method, req.URL.Path, req.URL.Query().Encode()}
+		cerr = &CheckerError{time.Now(), err, req.Method, req.URL.Path, req.URL.Query().Encode(), req.Header.Get("X-Bench-Request-ID")}
 	}
 
+	counter.IncKey("error|" + a.Method + "|" + normalizeLatencyPath(a.Path))
 	appendError(cerr)
 	return cerr
 }
@@ -321,7 +426,7 @@ func (c *Checker) NewRequest(method, uri string, body io.Reader) (*http.Request,
 	}
 
 	if parsedURL.Scheme == "" {
-		parsedURL.Scheme = "http"
+		parsedURL.Scheme = TargetScheme
 	}
 
 	parsedURL.Host = TorbAppHost
@@ -335,11 +440,130 @@ func (c *Checker) NewRequest(method, uri string, body io.Reader) (*http.Request,
 	return req, err
 }
 
+// Same cookie names in a response confuse the cookie jar, so just count them up to diagnose flaky sessions
+func checkDuplicatedSetCookie(res *http.Response) {
+	if len(res.Header["Set-Cookie"]) < 2 {
+		return
+	}
+	seen := map[string]bool{}
+	for _, cookie := range res.Cookies() {
+		if seen[cookie.Name] {
+			log.Printf("warn: Set-Cookie %s is duplicated (%s %s)\n", cookie.Name, res.Request.Method, res.Request.URL.Path)
+			counter.IncKey("set-cookie-duplicated")
+			return
+		}
+		seen[cookie.Name] = true
+	}
+}
+
+func gunzipBody(body *bytes.Buffer) (*bytes.Buffer, error) {
+	r, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decoded := GetBuffer()
+	_, err = io.Copy(decoded, r)
+	if err != nil {
+		PutBuffer(decoded)
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// Collapses numeric IDs in the path to ":id" to aggregate latencies per endpoint
+func normalizeLatencyPath(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if _, err := strconv.ParseInt(seg, 10, 64); err == nil {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+var versionRegexp = regexp.MustCompile(`\d+\.\d+`)
+
+// Counts responses disclosing the exact version of the server or framework, e.g., "nginx/1.15.0"
+func checkServerVersionDisclosure(res *http.Response) error {
+	for _, name := range []string{"Server", "X-Powered-By"} {
+		v := res.Header.Get(name)
+		if v == "" || !versionRegexp.MatchString(v) {
+			continue
+		}
+		counter.IncKey("server-version-disclosed")
+		if parameter.StrictServerHeader {
+			return fmt.Errorf("%sヘッダでバージョンが公開されています: %s", name, v)
+		}
+		log.Printf("debug: %s header discloses the version: %s (%s %s)\n", name, v, res.Request.Method, res.Request.URL.Path)
+		return nil
+	}
+	return nil
+}
+
+// Returns whether the request failed in the network, e.g., connection refused, reset or timeout
+func isNetworkError(err error) bool {
+	if urlError, ok := err.(*url.Error); ok {
+		err = urlError.Err
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// Checker.Play calls which have not returned yet
+var (
+	inFlightMtx   sync.Mutex
+	inFlightCount int
+	inFlightIdle  chan struct{} // closed when inFlightCount gets back to 0
+)
+
+func beginInFlightRequest() {
+	inFlightMtx.Lock()
+	defer inFlightMtx.Unlock()
+	if inFlightCount == 0 {
+		inFlightIdle = make(chan struct{})
+	}
+	inFlightCount++
+}
+
+func endInFlightRequest() {
+	inFlightMtx.Lock()
+	defer inFlightMtx.Unlock()
+	inFlightCount--
+	if inFlightCount == 0 {
+		close(inFlightIdle)
+	}
+}
+
+// Waits until all Checker.Play calls return or the timeout elapses. Returns false on timeout.
+func WaitInFlightRequests(timeout time.Duration) bool {
+	inFlightMtx.Lock()
+	count, idle := inFlightCount, inFlightIdle
+	inFlightMtx.Unlock()
+	if count == 0 {
+		return true
+	}
+
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
+	beginInFlightRequest()
+	defer endInFlightRequest()
+
 	select {
 	case token := <-c.chRequestToken:
 		defer func() {
@@ -352,7 +576,9 @@ func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 	var req *http.Request
 	var err error
 
-	if strings.ToUpper(a.Method) == "POST" {
+	// POST always has a body, and other methods (e.g. PUT, PATCH and DELETE) have one only if given
+	hasBody := strings.ToUpper(a.Method) == "POST" || a.PostBody != nil || a.PostData != nil || a.PostJSON != nil
+	if hasBody {
 		if a.PostBody != nil {
 			req, err = c.NewRequest(a.Method, a.Path, a.PostBody)
 			if req != nil {
@@ -396,13 +622,22 @@ func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 		req.Header.Set("X-Request-ID", fmt.Sprint(cnt))
 	}
 
+	if parameter.EnableBenchRequestID {
+		id := atomic.AddUint64(&benchRequestCounter, 1)
+		req.Header.Set("X-Bench-Request-ID", benchRequestIDPrefix+"-"+strconv.FormatUint(id, 10))
+	}
+
 	if a.EnableCache {
 		if cache, found := c.Cache.Get(a.Path); found {
 			cache.ApplyRequest(req)
 		}
 	}
 
-	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("User-Agent", c.userAgent)
+	if a.EnableGzip {
+		// NOTE: Setting the header disables the transparent decompression of net/http
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 	for key, val := range a.Headers {
 		req.Header.Add(key, val)
 	}
@@ -412,23 +647,65 @@ func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 		timeout = a.Timeout
 	} else {
 		timeout = GetTimeout
-		if req.Method == http.MethodPost {
+		if req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch {
 			timeout = PostTimeout
 		} else if req.Method == http.MethodDelete {
 			timeout = DeleteTimeout
 		}
 	}
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-	req = req.WithContext(ctx)
+	if a.StrictJSON {
+		ctx = context.WithValue(ctx, strictJSONKey{}, true)
+	}
+
+	start := time.Now()
+	defer func() {
+		d := time.Since(start)
+		counter.AddKey("request-time-us", int(d/time.Microsecond))
+		counter.Observe(a.Method+"|"+normalizeLatencyPath(a.Path), d)
+	}()
+
+	// Whether a connection was established, to tell which phase timed out
+	var connected int32
+	gotConnTrace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			atomic.StoreInt32(&connected, 1)
+		},
+	}
+
+	var res *http.Response
+	for retry := 0; ; retry++ {
+		reqCtx, cancel := context.WithTimeout(ctx, timeout)
+		atomic.StoreInt32(&connected, 0)
+		req = req.WithContext(httptrace.WithClientTrace(httptrace.WithClientTrace(reqCtx, connTrace), gotConnTrace))
 
-	tm := time.AfterFunc(SlowThreshold, func() {
-		if !a.DisableSlowChecking {
-			updateLastSlowPath(a.Path)
+		tm := time.AfterFunc(SlowThreshold, func() {
+			if !a.DisableSlowChecking {
+				updateLastSlowPath(a.Path)
+			}
+		})
+		if req.ContentLength > 0 {
+			counter.AddKey("bytes-out", int(req.ContentLength))
+		}
+		res, err = c.Client.Do(req)
+		tm.Stop()
+
+		if err == nil || retry >= a.MaxRetries || ctx.Err() != nil || !isNetworkError(err) {
+			// The context of the last attempt is kept until the body is read
+			defer cancel()
+			break
+		}
+		cancel()
+		if req.GetBody != nil {
+			req.Body, _ = req.GetBody()
 		}
-	})
-	res, err := c.Client.Do(req)
-	tm.Stop()
+
+		log.Printf("debug: retry %s %s (%d/%d): %v\n", a.Method, a.Path, retry+1, a.MaxRetries, err)
+		counter.IncKey("request-retried")
+		select {
+		case <-time.After(a.RetryBackoff << uint(retry)):
+		case <-ctx.Done():
+		}
+	}
 
 	isRedirectErr := false
 	if urlError, ok := err.(*url.Error); ok && urlError.Err == RedirectAttemptedError {
@@ -439,6 +716,11 @@ func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 		switch e := err.(type) {
 		case net.Error:
 			if e.Timeout() {
+				if atomic.LoadInt32(&connected) == 0 {
+					counter.IncKey("timeout-connect")
+					return c.OnError(a, req, ConnectTimeoutError)
+				}
+				counter.IncKey("timeout-response")
 				return c.OnError(a, req, RequestTimeoutError)
 			}
 		}
@@ -452,17 +734,47 @@ func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 
 	defer res.Body.Close()
 
+	checkDuplicatedSetCookie(res)
+
+	if parameter.ServerHeaderSampleRate > 0 && rand.Intn(parameter.ServerHeaderSampleRate) == 0 {
+		if err := checkServerVersionDisclosure(res); err != nil {
+			return c.OnError(a, res.Request, err)
+		}
+	}
+
 	body := GetBuffer()
 	defer PutBuffer(body)
 
-	_, err = io.Copy(body, res.Body)
+	if a.StreamCheckFunc == nil {
+		_, err = io.Copy(body, res.Body)
+	}
 	if err == context.DeadlineExceeded {
+		counter.IncKey("timeout-response")
 		return c.OnError(a, req, RequestTimeoutError)
 	}
+	// Note. chunked で返されたレスポンスも net/http がデコードするので Content-Length の有無によらず同じ body になる
+	// 終端チャンクや Content-Length 分のデータが届く前に切断された場合はここで検出する
+	if err == io.ErrUnexpectedEOF {
+		return c.OnError(a, req, fmt.Errorf("レスポンスボディの読み込みに失敗しました"))
+	}
 	// Note. リダイレクトなどのときはbodyが既に閉じられている状態で来て closed error が返るので無視する
 
+	// Counts the body as received, i.e., compressed if EnableGzip
+	counter.AddKey("bytes-in", body.Len())
+
+	if a.EnableGzip && a.StreamCheckFunc == nil && res.Header.Get("Content-Encoding") == "gzip" {
+		counter.AddKey("gzip-compressed-bytes", body.Len())
+		decoded, err := gunzipBody(body)
+		if err != nil {
+			return c.OnError(a, req, fmt.Errorf("gzipされたレスポンスボディの展開に失敗しました"))
+		}
+		defer PutBuffer(decoded)
+		counter.AddKey("gzip-decompressed-bytes", decoded.Len())
+		body = decoded
+	}
+
 	if 500 <= res.StatusCode {
-		return c.OnError(a, res.Request, fmt.Errorf("サーバエラーが発生しました。%s", res.Status))
+		return c.OnError(a, res.Request, &serverError{res.Status})
 	}
 
 	if a.ExpectedStatusCode != 0 && res.StatusCode != a.ExpectedStatusCode {
@@ -493,14 +805,25 @@ func (c *Checker) Play(ctx context.Context, a *CheckAction) error {
 		}
 	}
 
-	if res.StatusCode == 200 && a.EnableCache {
+	if res.StatusCode == 200 && a.EnableCache && a.StreamCheckFunc == nil {
 		cache, _ := urlcache.NewURLCache(res, body)
 		if cache != nil {
 			c.Cache.Set(a.Path, cache)
 		}
 	}
 
-	if a.CheckFunc != nil {
+	if a.StreamCheckFunc != nil {
+		cr := &countingReader{r: res.Body}
+		err := a.StreamCheckFunc(res, cr)
+		counter.AddKey("bytes-in", int(cr.n))
+		if err == context.DeadlineExceeded {
+			counter.IncKey("timeout-response")
+			return c.OnError(a, res.Request, RequestTimeoutError)
+		}
+		if err != nil {
+			return c.OnError(a, res.Request, err)
+		}
+	} else if a.CheckFunc != nil {
 		if err := a.CheckFunc(res, body); err != nil {
 			if a.EnableCache {
 				c.Cache.Del(a.Path)
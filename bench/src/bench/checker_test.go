@@ -0,0 +1,618 @@
+package bench
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bench/counter"
+	"bench/parameter"
+)
+
+// Serves path whose first k attempts time out
+func handleTestFlakyPath(mux *http.ServeMux, path string, k int32, status int) *int32 {
+	var attempts int32
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= k {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(status)
+	})
+	return &attempts
+}
+
+func TestPlayRetry(t *testing.T) {
+	mux := http.NewServeMux()
+	flaky := handleTestFlakyPath(mux, "/flaky", 2, 200)
+	tooFlaky := handleTestFlakyPath(mux, "/too-flaky", 3, 200)
+	broken := handleTestFlakyPath(mux, "/broken", 0, 500)
+	invalid := handleTestFlakyPath(mux, "/invalid", 0, 200)
+	defer startTestServer(mux)()
+
+	checker := NewChecker()
+	play := func(path string, checkFunc func(*http.Response, *bytes.Buffer) error) error {
+		return checker.Play(context.Background(), &CheckAction{
+			Method:             "GET",
+			Path:               path,
+			ExpectedStatusCode: 200,
+			Timeout:            100 * time.Millisecond,
+			MaxRetries:         2,
+			RetryBackoff:       time.Millisecond,
+			CheckFunc:          checkFunc,
+		})
+	}
+
+	if err := play("/flaky", nil); err != nil {
+		t.Errorf("expected to succeed on the 3rd attempt, got %v", err)
+	}
+	if n := atomic.LoadInt32(flaky); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	if err := play("/too-flaky", nil); !IsCheckerTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if n := atomic.LoadInt32(tooFlaky); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	// Unexpected status codes and CheckFunc failures are not retried
+	if err := play("/broken", nil); !IsCheckerServerError(err) {
+		t.Errorf("expected a server error, got %v", err)
+	}
+	if n := atomic.LoadInt32(broken); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+	err := play("/invalid", func(*http.Response, *bytes.Buffer) error {
+		return fatalErrorf("invalid")
+	})
+	if !IsCheckerFatal(err) {
+		t.Errorf("expected a fatal error, got %v", err)
+	}
+	if n := atomic.LoadInt32(invalid); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestPlayRetryConnectionRefused(t *testing.T) {
+	// Find a port nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	SetTargetHosts([]string{addr})
+
+	retried := counter.GetKey("request-retried")
+	err = NewChecker().Play(context.Background(), &CheckAction{
+		Method:       "GET",
+		Path:         "/",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := counter.GetKey("request-retried") - retried; n != 2 {
+		t.Errorf("expected 2 retries, got %d", n)
+	}
+}
+
+func TestPlayNoRetryOnMalformedResponse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var attempts int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&attempts, 1)
+			conn.Write([]byte("HTTP/1.1 abc\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	SetTargetHosts([]string{l.Addr().String()})
+
+	err = NewChecker().Play(context.Background(), &CheckAction{
+		Method:       "GET",
+		Path:         "/",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestNormalizeLatencyPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/":                                     "/",
+		"/api/events/12":                        "/api/events/:id",
+		"/api/events/12/sheets/S/3/reservation": "/api/events/:id/sheets/S/:id/reservation",
+		"/admin/api/reports/sales?chunked=1":    "/admin/api/reports/sales",
+		"/css/app.css":                          "/css/app.css",
+	} {
+		if got := normalizeLatencyPath(path); got != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, got)
+		}
+	}
+}
+
+// Serves content gzipped if accepted, with an ETag to be revalidated.
+// Returns the size of the last gzipped body.
+func handleTestGzip(mux *http.ServeMux, path string, contentType string, content []byte) *int64 {
+	var compressed int64
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"test"`)
+		if r.Header.Get("If-None-Match") == `"test"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write(content)
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		gw.Write(content)
+		gw.Close()
+		atomic.StoreInt64(&compressed, int64(buf.Len()))
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	})
+	return &compressed
+}
+
+func TestPlayGzip(t *testing.T) {
+	jsonBody := []byte(`{"error":"` + strings.Repeat("a", 4096) + `"}`)
+	css := []byte(strings.Repeat("body { margin: 0; }\n", 256))
+	mux := http.NewServeMux()
+	jsonCompressed := handleTestGzip(mux, "/api/json", "application/json", jsonBody)
+	cssCompressed := handleTestGzip(mux, "/css/app.css", "text/css", css)
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte("not gzipped"))
+	})
+	defer startTestServer(mux)()
+
+	checker := NewChecker()
+
+	// JSON is decoded from the decompressed body, and bytes-in counts the compressed one
+	bytesIn := counter.GetKey("bytes-in")
+	decompressed := counter.GetKey("gzip-decompressed-bytes")
+	err := checker.Play(context.Background(), &CheckAction{
+		Method:             "GET",
+		Path:               "/api/json",
+		ExpectedStatusCode: 200,
+		EnableGzip:         true,
+		CheckFunc:          checkJsonErrorResponse(strings.Repeat("a", 4096)),
+	})
+	if err != nil {
+		t.Errorf("json: unexpected error %v", err)
+	}
+	if n := counter.GetKey("bytes-in") - bytesIn; n != atomic.LoadInt64(jsonCompressed) || n >= int64(len(jsonBody)) {
+		t.Errorf("json: bytes-in should be the compressed size %d, got %d", atomic.LoadInt64(jsonCompressed), n)
+	}
+	if n := counter.GetKey("gzip-decompressed-bytes") - decompressed; n != int64(len(jsonBody)) {
+		t.Errorf("json: expected %d decompressed bytes, got %d", len(jsonBody), n)
+	}
+
+	// A static file is cached by the hash of the decompressed body and then revalidated
+	hash := md5.Sum(css)
+	playCSS := func() (int, error) {
+		status := 0
+		err := checker.Play(context.Background(), &CheckAction{
+			Method:      "GET",
+			Path:        "/css/app.css",
+			EnableCache: true,
+			EnableGzip:  true,
+			CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+				status = res.StatusCode
+				if res.StatusCode == http.StatusOK && md5.Sum(body.Bytes()) != hash {
+					return fatalErrorf("静的ファイルの内容が正しくありません")
+				}
+				return nil
+			},
+		})
+		return status, err
+	}
+
+	bytesIn = counter.GetKey("bytes-in")
+	if status, err := playCSS(); err != nil || status != http.StatusOK {
+		t.Fatalf("css: expected 200, got %d %v", status, err)
+	}
+	if n := counter.GetKey("bytes-in") - bytesIn; n != atomic.LoadInt64(cssCompressed) {
+		t.Errorf("css: bytes-in should be the compressed size %d, got %d", atomic.LoadInt64(cssCompressed), n)
+	}
+	cache, found := checker.Cache.Get("/css/app.css")
+	if !found || cache.MD5 != hex.EncodeToString(hash[:]) {
+		t.Errorf("css: expected to be cached with the hash of the decompressed body, got %+v", cache)
+	}
+	if status, err := playCSS(); err != nil || status != http.StatusNotModified {
+		t.Errorf("css: expected 304, got %d %v", status, err)
+	}
+
+	// A body which is not actually gzipped is an error
+	err = checker.Play(context.Background(), &CheckAction{
+		Method:     "GET",
+		Path:       "/broken",
+		EnableGzip: true,
+	})
+	if err == nil {
+		t.Error("broken: expected an error")
+	}
+}
+
+func TestPlayTLS(t *testing.T) {
+	defer func(scheme string, insecure bool) {
+		TargetScheme = scheme
+		TLSClientConfig.InsecureSkipVerify = insecure
+	}(TargetScheme, TLSClientConfig.InsecureSkipVerify)
+	TargetScheme = "https"
+
+	css := []byte("body { margin: 0; }\n")
+	hash := md5.Sum(css)
+	defer func(v []*StaticFile) { StaticFiles = v }(StaticFiles)
+	StaticFiles = []*StaticFile{{"/css/layout.css", int64(len(css)), hex.EncodeToString(hash[:])}}
+
+	state := newTestState()
+	addTestUser(state, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "torb_session", Value: "tls", Path: "/", HttpOnly: true, Secure: true})
+		w.WriteHeader(200)
+	})
+	mux.HandleFunc("/api/users/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			w.WriteHeader(400)
+			return
+		}
+		if cookie, err := r.Cookie("torb_session"); err != nil || cookie.Value != "tls" {
+			w.WriteHeader(401)
+			return
+		}
+		w.WriteHeader(200)
+	})
+	mux.HandleFunc("/css/layout.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(css)
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+	SetTargetHosts([]string{strings.TrimPrefix(ts.URL, "https://")})
+
+	// The self-signed certificate is rejected unless insecure
+	err := NewChecker().Play(context.Background(), &CheckAction{Method: "GET", Path: "/css/layout.css"})
+	if err == nil {
+		t.Error("expected a certificate error")
+	}
+	TLSClientConfig.InsecureSkipVerify = true
+
+	// The secure cookie persists across requests over TLS
+	checker := NewChecker()
+	for _, path := range []string{"/api/actions/login", "/api/users/1"} {
+		err := checker.Play(context.Background(), &CheckAction{
+			Method:             "GET",
+			Path:               path,
+			ExpectedStatusCode: 200,
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", path, err)
+		}
+	}
+
+	// Static files are hashed identically over TLS
+	if err := CheckStaticFiles(context.Background(), state); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestShutdownMidRun(t *testing.T) {
+	defer func(v int) { parameter.AssetConcurrency = v }(parameter.AssetConcurrency)
+	parameter.AssetConcurrency = 4
+	// Start a pool of the size for this test
+	StopAssetPool()
+	assetPoolOnce = sync.Once{}
+
+	var started int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&started, 1)
+		<-r.Context().Done()
+	})
+	defer startTestServer(mux)()
+	baseline := runtime.NumGoroutine()
+
+	// Loaders and assets blocked on the server
+	ctx, cancel := context.WithCancel(context.Background())
+	var loaders sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		checker := NewChecker()
+		goLoadStaticFiles(ctx, checker, "/asset/1", "/asset/2")
+		loaders.Add(1)
+		go func() {
+			defer loaders.Done()
+			checker.Play(ctx, &CheckAction{Method: "GET", Path: "/", ExpectedStatusCode: 200, Description: "test"})
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&started) < 8 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&started); n < 8 {
+		t.Fatalf("expected 8 requests to be in-flight, got %d", n)
+	}
+	if WaitInFlightRequests(50 * time.Millisecond) {
+		t.Fatal("expected in-flight requests not to return before cancel")
+	}
+
+	cancel()
+	if !WaitInFlightRequests(5 * time.Second) {
+		t.Fatal("in-flight requests did not return after cancel")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		loaders.Wait()
+		StopAssetPool()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loaders or asset workers did not return after cancel")
+	}
+
+	// Connections of the canceled requests are closed asynchronously
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("expected %d goroutines after shutdown, got %d", baseline, n)
+	}
+}
+
+func TestPlayConnectTimeout(t *testing.T) {
+	defer func(scheme string) { TargetScheme = scheme }(TargetScheme)
+	TargetScheme = "https"
+
+	// Accepts connections but never completes the TLS handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	SetTargetHosts([]string{l.Addr().String()})
+
+	before := counter.GetKey("timeout-connect")
+	err = NewChecker().Play(context.Background(), &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Timeout:            200 * time.Millisecond,
+	})
+	cerr, ok := err.(*CheckerError)
+	if !ok || !cerr.IsConnectTimeout() || !cerr.IsTimeout() {
+		t.Fatalf("expected a connect timeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), ConnectTimeoutError.Error()) {
+		t.Errorf("expected the error to tell the connect timeout, got %v", err)
+	}
+	if n := counter.GetKey("timeout-connect") - before; n != 1 {
+		t.Errorf("expected timeout-connect to be counted once, got %d", n)
+	}
+}
+
+func TestPlayResponseTimeout(t *testing.T) {
+	// Sends the header and a part of the body, then stalls
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(200)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	defer startTestServer(mux)()
+
+	beforeConnect := counter.GetKey("timeout-connect")
+	beforeResponse := counter.GetKey("timeout-response")
+	err := NewChecker().Play(context.Background(), &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Timeout:            200 * time.Millisecond,
+	})
+	cerr, ok := err.(*CheckerError)
+	if !ok || !cerr.IsTimeout() || cerr.IsConnectTimeout() {
+		t.Fatalf("expected a response timeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), RequestTimeoutError.Error()) {
+		t.Errorf("expected the error to tell the response timeout, got %v", err)
+	}
+	if n := counter.GetKey("timeout-response") - beforeResponse; n != 1 {
+		t.Errorf("expected timeout-response to be counted once, got %d", n)
+	}
+	if n := counter.GetKey("timeout-connect") - beforeConnect; n != 0 {
+		t.Errorf("expected timeout-connect not to be counted, got %d", n)
+	}
+}
+
+func TestPlayMethodsWithBody(t *testing.T) {
+	type received struct {
+		method      string
+		contentType string
+		body        string
+	}
+	ch := make(chan received, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		ch <- received{r.Method, r.Header.Get("Content-Type"), string(b)}
+	})
+	defer startTestServer(mux)()
+
+	payload := map[string]interface{}{"public": true, "title": "イベント"}
+	jsonBody, _ := json.Marshal(payload)
+	for _, c := range []struct {
+		action   CheckAction
+		expected received
+	}{
+		{CheckAction{Method: "PATCH", PostJSON: payload}, received{"PATCH", "application/json", string(jsonBody)}},
+		{CheckAction{Method: "PUT", PostJSON: payload}, received{"PUT", "application/json", string(jsonBody)}},
+		{CheckAction{Method: "DELETE", PostJSON: payload}, received{"DELETE", "application/json", string(jsonBody)}},
+		{CheckAction{Method: "PUT", ContentType: "text/plain", PostBody: strings.NewReader("raw body")}, received{"PUT", "text/plain", "raw body"}},
+		{CheckAction{Method: "GET"}, received{"GET", "", ""}},
+	} {
+		a := c.action
+		a.Path = "/echo"
+		a.ExpectedStatusCode = 200
+		if err := NewChecker().Play(context.Background(), &a); err != nil {
+			t.Errorf("%s: unexpected error %v", a.Method, err)
+			continue
+		}
+		got := <-ch
+		got.body = strings.TrimSpace(got.body)
+		if got != c.expected {
+			t.Errorf("%s: got %+v, expected %+v", a.Method, got, c.expected)
+		}
+	}
+}
+
+func TestPlayByteCounts(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bytes", func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write(content[:1234])
+	})
+	compressed := handleTestGzip(mux, "/gzip", "text/plain", content)
+	defer startTestServer(mux)()
+
+	play := func(checker *Checker, a *CheckAction) (in, out int64) {
+		beforeIn, beforeOut := counter.GetKey("bytes-in"), counter.GetKey("bytes-out")
+		if a.ExpectedStatusCode == 0 {
+			a.ExpectedStatusCode = 200
+		}
+		if err := checker.Play(context.Background(), a); err != nil {
+			t.Fatalf("%s %s: unexpected error %v", a.Method, a.Path, err)
+		}
+		return counter.GetKey("bytes-in") - beforeIn, counter.GetKey("bytes-out") - beforeOut
+	}
+
+	payload := map[string]interface{}{"sheet_rank": "S"}
+	jsonBody, _ := json.Marshal(payload)
+	if in, out := play(NewChecker(), &CheckAction{Method: "POST", Path: "/bytes", PostJSON: payload}); in != 1234 || out != int64(len(jsonBody)) {
+		t.Errorf("buffered: got %d bytes in and %d bytes out, expected 1234 and %d", in, out, len(jsonBody))
+	}
+
+	// The streamed body is counted once as it is read
+	stream := &CheckAction{Method: "GET", Path: "/bytes", StreamCheckFunc: func(res *http.Response, body io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, body)
+		return err
+	}}
+	if in, out := play(NewChecker(), stream); in != 1234 || out != 0 {
+		t.Errorf("streamed: got %d bytes in and %d bytes out, expected 1234 and 0", in, out)
+	}
+
+	// The compressed size is counted, and nothing for 304 Not Modified of the cached one
+	checker := NewChecker()
+	if in, _ := play(checker, &CheckAction{Method: "GET", Path: "/gzip", EnableGzip: true, EnableCache: true}); in != atomic.LoadInt64(compressed) {
+		t.Errorf("gzip: got %d bytes in, expected %d", in, atomic.LoadInt64(compressed))
+	}
+	if in, _ := play(checker, &CheckAction{Method: "GET", Path: "/gzip", EnableGzip: true, EnableCache: true, ExpectedStatusCode: 304}); in != 0 {
+		t.Errorf("cached: got %d bytes in, expected 0", in)
+	}
+}
+
+// Compares allocations of reading a body into a fresh buffer, as Play did before, and into a pooled one.
+// Run with -benchmem
+func BenchmarkBodyBuffer(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body := new(bytes.Buffer)
+			io.Copy(body, bytes.NewReader(content))
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body := GetBuffer()
+			io.Copy(body, bytes.NewReader(content))
+			PutBuffer(body)
+		}
+	})
+}
+
+// Plays a cached asset, whose 200 and 304 bodies are drained into the pooled buffer
+func BenchmarkPlayCachedAsset(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/asset", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"bench"`)
+		if r.Header.Get("If-None-Match") == `"bench"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(content)
+	})
+	defer startTestServer(mux)()
+
+	checker := NewChecker()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Alternate 200 and 304 Not Modified
+		if i%2 == 0 {
+			checker.Cache.Del("/asset")
+		}
+		err := checker.Play(context.Background(), &CheckAction{Method: "GET", Path: "/asset", EnableCache: true})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -79,3 +79,15 @@ func GetMap() map[string]int64 {
 	mtx.Unlock()
 	return m
 }
+
+// Reset zeroes all counters and histograms, e.g., at the end of warmup.
+// Increments after Reset returns are kept, and ones before are discarded.
+func Reset() {
+	mtx.Lock()
+	cntMap = map[string]int64{}
+	mtx.Unlock()
+
+	histMtx.Lock()
+	histMap = map[string]*histogram{}
+	histMtx.Unlock()
+}
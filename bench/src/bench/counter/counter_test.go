@@ -0,0 +1,28 @@
+package counter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReset(t *testing.T) {
+	IncKey("test-reset")
+	Observe("GET|/test-reset", time.Millisecond)
+
+	Reset()
+	if n := GetKey("test-reset"); n != 0 {
+		t.Errorf("expected 0 after reset, got %d", n)
+	}
+	if keys := ObservedKeys(); len(keys) != 0 {
+		t.Errorf("expected no observed keys after reset, got %v", keys)
+	}
+
+	IncKey("test-reset")
+	Observe("GET|/test-reset", time.Millisecond)
+	if n := GetKey("test-reset"); n != 1 {
+		t.Errorf("expected 1 after reset, got %d", n)
+	}
+	if n := ObservedCount("GET|/test-reset"); n != 1 {
+		t.Errorf("expected 1 observation after reset, got %d", n)
+	}
+}
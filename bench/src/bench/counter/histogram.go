@@ -0,0 +1,138 @@
+package counter
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log-scale histogram of durations in microseconds.
+// Each power of 2 is divided into histSubBuckets, so the error of a percentile is within 1/histSubBuckets.
+// Buckets are updated atomically to keep Observe cheap under heavy concurrency.
+
+const (
+	histSubBits    = 2
+	histSubBuckets = 1 << histSubBits
+	histMaxBits    = 40 // about 12 days in microseconds
+	histNumBuckets = (histMaxBits + 1) * histSubBuckets
+)
+
+type histogram struct {
+	buckets [histNumBuckets]int64
+	count   int64
+}
+
+var (
+	histMtx sync.RWMutex
+	histMap = map[string]*histogram{}
+)
+
+func histBucket(us int64) int {
+	if us < histSubBuckets {
+		return int(us)
+	}
+	msb := uint(0)
+	for v := us; v > 1; v >>= 1 {
+		msb++
+	}
+	if msb > histMaxBits {
+		return histNumBuckets - 1
+	}
+	sub := (us >> (msb - histSubBits)) & (histSubBuckets - 1)
+	return int(msb-histSubBits+1)*histSubBuckets + int(sub)
+}
+
+// Returns the upper bound of the bucket in microseconds
+func histBucketUpper(i int) int64 {
+	if i < histSubBuckets {
+		return int64(i)
+	}
+	msb := uint(i/histSubBuckets) + histSubBits - 1
+	sub := int64(i % histSubBuckets)
+	return (histSubBuckets+sub+1)<<(msb-histSubBits) - 1
+}
+
+func (h *histogram) observe(us int64) {
+	atomic.AddInt64(&h.buckets[histBucket(us)], 1)
+	atomic.AddInt64(&h.count, 1)
+}
+
+// Observe records a duration of the key, e.g., latency of an endpoint
+func Observe(key string, d time.Duration) {
+	us := int64(d / time.Microsecond)
+	if us < 0 {
+		us = 0
+	}
+
+	// Observe under the lock so that Reset never loses an observation in the middle
+	histMtx.RLock()
+	h, ok := histMap[key]
+	if ok {
+		h.observe(us)
+	}
+	histMtx.RUnlock()
+	if ok {
+		return
+	}
+
+	histMtx.Lock()
+	defer histMtx.Unlock()
+	if h, ok = histMap[key]; !ok {
+		h = &histogram{}
+		histMap[key] = h
+	}
+	h.observe(us)
+}
+
+// Percentile returns the p-th (0 < p <= 100) percentile of durations observed for the key, or 0 if none
+func Percentile(key string, p float64) time.Duration {
+	histMtx.RLock()
+	h, ok := histMap[key]
+	histMtx.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	count := atomic.LoadInt64(&h.count)
+	if count == 0 {
+		return 0
+	}
+	rank := int64(float64(count)*p/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var sum int64
+	for i := range h.buckets {
+		sum += atomic.LoadInt64(&h.buckets[i])
+		if sum >= rank {
+			return time.Duration(histBucketUpper(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(histBucketUpper(histNumBuckets-1)) * time.Microsecond
+}
+
+// ObservedKeys returns keys passed to Observe in sorted order
+func ObservedKeys() []string {
+	histMtx.RLock()
+	keys := make([]string, 0, len(histMap))
+	for k := range histMap {
+		keys = append(keys, k)
+	}
+	histMtx.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// ObservedCount returns the number of durations observed for the key
+func ObservedCount(key string) int64 {
+	histMtx.RLock()
+	h, ok := histMap[key]
+	histMtx.RUnlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(&h.count)
+}
@@ -0,0 +1,78 @@
+package counter
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	key := "GET|/test-percentile"
+	for i := 1; i <= 1000; i++ {
+		Observe(key, time.Duration(i)*time.Millisecond)
+	}
+
+	if n := ObservedCount(key); n != 1000 {
+		t.Fatalf("expected 1000 observations, got %d", n)
+	}
+	for _, p := range []float64{50, 90, 99} {
+		expected := time.Duration(p*10) * time.Millisecond
+		got := Percentile(key, p)
+		// The error is within 1/histSubBuckets of the value
+		if got < expected || expected+expected/histSubBuckets < got {
+			t.Errorf("p%.0f: expected about %s, got %s", p, expected, got)
+		}
+	}
+	if d := Percentile("GET|/not-observed", 50); d != 0 {
+		t.Errorf("expected 0 for unknown key, got %s", d)
+	}
+}
+
+func TestObserveConcurrently(t *testing.T) {
+	key := "GET|/test-concurrent"
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				Observe(key, time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := ObservedCount(key); n != 16*10000 {
+		t.Errorf("expected %d observations, got %d", 16*10000, n)
+	}
+}
+
+// Run with -cpu 1,8 and compare with BenchmarkIncKeyParallel to see Observe does not degrade under contention
+func BenchmarkObserveParallel(b *testing.B) {
+	keys := make([]string, 8)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET|/bench/%d", i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			Observe(keys[i%len(keys)], time.Duration(i)*time.Microsecond)
+			i++
+		}
+	})
+}
+
+func BenchmarkIncKeyParallel(b *testing.B) {
+	keys := make([]string, 8)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET|/bench/%d", i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			IncKey(keys[i%len(keys)])
+			i++
+		}
+	})
+}
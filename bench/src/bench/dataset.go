@@ -283,8 +283,10 @@ func prepareReservationsDataSet() {
 		}
 
 		user := DataSet.Users[r.UserID-1]
-		user.Status.PositiveTotalPrice += r.Price
-		user.Status.NegativeTotalPrice += r.Price
+		if r.CanceledAt == 0 {
+			user.Status.PositiveTotalPrice += r.Price
+			user.Status.NegativeTotalPrice += r.Price
+		}
 
 		reservedAt := time.Unix(int64(r.ReservedAt), 0)
 		user.Status.LastMaybeReservedEvent.SetIDWithTime(r.EventID, reservedAt)
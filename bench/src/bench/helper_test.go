@@ -0,0 +1,329 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	prepareSheetDataSet()
+	os.Exit(m.Run())
+}
+
+// Returns an initialized state without any users, administrators, events and reservations
+func newTestState() *State {
+	state := new(State)
+	state.Init()
+	return state
+}
+
+func addTestUser(state *State, id uint) *AppUser {
+	user := &AppUser{
+		ID:        id,
+		Nickname:  RandomAlphabetString(8),
+		LoginName: RandomAlphabetString(8),
+		Password:  RandomAlphabetString(8),
+	}
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	state.pushInitialUserLocked(user)
+	return user
+}
+
+func addTestAdmin(state *State, id uint) *Administrator {
+	admin := &Administrator{
+		ID:        id,
+		Nickname:  RandomAlphabetString(8),
+		LoginName: RandomAlphabetString(8),
+		Password:  RandomAlphabetString(8),
+	}
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	state.pushInitialAdministratorLocked(admin)
+	return admin
+}
+
+func addTestEvent(state *State, id uint, price uint) *Event {
+	event := &Event{
+		ID:       id,
+		Title:    RandomAlphabetString(16),
+		PublicFg: true,
+		Price:    price,
+	}
+	state.PushNewEvent(event, time.Now(), "test")
+	return event
+}
+
+// Records a completed reservation as if the benchmarker had reserved it
+func addTestReservation(state *State, user *AppUser, reservation *Reservation) *Reservation {
+	logID := state.BeginReservation(user, reservation)
+	if err := state.CommitReservation(logID, user, reservation); err != nil {
+		panic(err)
+	}
+	return reservation
+}
+
+// Records a reservation as if it were in the initial dataset
+func addTestInitialReservation(state *State, user *AppUser, reservation *Reservation) *Reservation {
+	if reservation.ReservedAt == 0 {
+		reservation.ReservedAt = time.Now().Unix()
+	}
+	reservation.ReserveCompletedAt = time.Unix(reservation.ReservedAt, 0)
+	if reservation.CanceledAt != 0 {
+		reservation.CancelRequestedAt = time.Unix(reservation.CanceledAt, 0)
+		reservation.CancelCompletedAt = time.Unix(reservation.CanceledAt, 0)
+	}
+	state.reservations[reservation.ID] = reservation
+	state.reserveRequestedCount++
+	state.reserveCompletedCount++
+	if reservation.CanceledAt == 0 {
+		user.Status.PositiveTotalPrice += reservation.Price
+		user.Status.NegativeTotalPrice += reservation.Price
+	}
+	return reservation
+}
+
+// Routes the checkers to handler until the returned function is called
+func startTestServer(handler http.Handler) func() {
+	ts := httptest.NewServer(handler)
+	SetTargetHosts([]string{strings.TrimPrefix(ts.URL, "http://")})
+	return ts.Close
+}
+
+// Serves the login API for users
+func handleTestLogin(mux *http.ServeMux, users ...*AppUser) {
+	mux.HandleFunc("/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			LoginName string `json:"login_name"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, user := range users {
+			if user.LoginName == req.LoginName {
+				http.SetCookie(w, &http.Cookie{Name: "torb_session", Value: RandomAlphabetString(16), Path: "/", HttpOnly: true})
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(JsonUser{ID: user.ID, Nickname: user.Nickname})
+				return
+			}
+		}
+		w.WriteHeader(401)
+	})
+}
+
+// Serves the administrator login API for admins
+func handleTestAdminLogin(mux *http.ServeMux, admins ...*Administrator) {
+	mux.HandleFunc("/admin/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			LoginName string `json:"login_name"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, admin := range admins {
+			if admin.LoginName == req.LoginName {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(JsonAdministrator{ID: admin.ID, Nickname: admin.Nickname})
+				return
+			}
+		}
+		w.WriteHeader(401)
+	})
+}
+
+// Returns the event JSON with no sheets reserved
+func newTestJsonFullEvent(id uint, title string, price uint, public, closed bool) JsonFullEvent {
+	e := JsonFullEvent{
+		JsonEvent: JsonEvent{ID: id, Title: title, Sheets: map[string]JsonSheet{}},
+		Price:     price,
+		Public:    public,
+		Closed:    closed,
+	}
+	for _, sheetKind := range DataSet.SheetKinds {
+		e.Sheets[sheetKind.Rank] = JsonSheet{Price: price + sheetKind.Price, Total: sheetKind.Total, Remains: sheetKind.Total}
+		e.Total += sheetKind.Total
+		e.Remains += sheetKind.Total
+	}
+	return e
+}
+
+// Serves the event creation API which takes delay for each request.
+// Returns a function to get the number of created events.
+func handleTestCreateEvent(mux *http.ServeMux, delay time.Duration) func() int {
+	var mtx sync.Mutex
+	nextID := uint(1)
+	mux.HandleFunc("/admin/api/events", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Title  string `json:"title"`
+			Public bool   `json:"public"`
+			Price  uint   `json:"price"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		time.Sleep(delay)
+
+		mtx.Lock()
+		id := nextID
+		nextID++
+		mtx.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newTestJsonFullEvent(id, req.Title, req.Price, req.Public, false))
+	})
+	return func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return int(nextID - 1)
+	}
+}
+
+// Serves the event edit API which returns the event in state with the requested flags
+func handleTestEditEvent(mux *http.ServeMux, state *State) {
+	mux.HandleFunc("/admin/api/events/", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Public bool `json:"public"`
+			Closed bool `json:"closed"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var id uint
+		fmt.Sscanf(r.URL.Path, "/admin/api/events/%d/actions/edit", &id)
+		event := state.FindEventByID(id)
+		if event == nil {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newTestJsonFullEvent(event.ID, event.Title, event.Price, req.Public, req.Closed))
+	})
+}
+
+// Serves the reserve API which hands out sheets of each rank of each event in order,
+// and returns sold_out after soldOutAfter reservations of the rank, never if it is negative.
+// Also serves the cancel API and the event API of events in state without reservations.
+// Returns a function to get the number of accepted reservations.
+func handleTestReserve(mux *http.ServeMux, state *State, soldOutAfter int) func() int {
+	var mtx sync.Mutex
+	reserved := map[string]int{}
+	accepted := 0
+	mux.HandleFunc("/api/events/", func(w http.ResponseWriter, r *http.Request) {
+		var eventID, num uint
+		var rank string
+		if _, err := fmt.Sscanf(r.URL.Path, "/api/events/%d/sheets/%1s/%d/reservation", &eventID, &rank, &num); err == nil && r.Method == "DELETE" {
+			w.WriteHeader(204)
+			return
+		}
+		if r.Method == "GET" {
+			fmt.Sscanf(r.URL.Path, "/api/events/%d", &eventID)
+			e := state.FindEventByID(eventID)
+			if e == nil {
+				w.WriteHeader(404)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newTestJsonFullEvent(e.ID, e.Title, e.Price, e.PublicFg, e.ClosedFg))
+			return
+		}
+		var req struct {
+			SheetRank string `json:"sheet_rank"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Sscanf(r.URL.Path, "/api/events/%d/actions/reserve", &eventID)
+
+		mtx.Lock()
+		key := fmt.Sprintf("%d-%s", eventID, req.SheetRank)
+		if soldOutAfter >= 0 && reserved[key] >= soldOutAfter {
+			mtx.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(409)
+			json.NewEncoder(w).Encode(JsonError{Error: "sold_out"})
+			return
+		}
+		reserved[key]++
+		accepted++
+		reservation := JsonReservation{ReservationID: uint(accepted), SheetRank: req.SheetRank, SheetNum: uint(reserved[key])}
+		mtx.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(reservation)
+	})
+	return func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return accepted
+	}
+}
+
+// Returns report rows of reservations in the order of reservation id
+func testReportRows(reservations map[uint]*Reservation) [][]string {
+	rows := [][]string{reportHeader}
+	for id := uint(1); len(rows) <= len(reservations); id++ {
+		r, ok := reservations[id]
+		if !ok {
+			continue
+		}
+		canceledAt := ""
+		if !r.CancelCompletedAt.IsZero() {
+			canceledAt = r.CancelCompletedAt.UTC().Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			fmt.Sprint(r.ID), fmt.Sprint(r.EventID), r.SheetRank, fmt.Sprint(r.SheetNum), fmt.Sprint(r.Price),
+			fmt.Sprint(r.UserID), r.ReserveCompletedAt.UTC().Format(time.RFC3339), canceledAt,
+		})
+	}
+	return rows
+}
+
+// Writes rows as CSV. If chunked, each row is flushed with Transfer-Encoding: chunked,
+// otherwise the whole body is written with Content-Length.
+func serveTestReport(w http.ResponseWriter, rows [][]string, chunked bool) {
+	w.Header().Set("Content-Type", "text/csv")
+	if chunked {
+		writer := csv.NewWriter(w)
+		for _, row := range rows {
+			writer.Write(row)
+			writer.Flush()
+			w.(http.Flusher).Flush()
+		}
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	writer.WriteAll(rows)
+	w.Header().Set("Content-Length", fmt.Sprint(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// Parses a report body like checkReportResponse does before checking records against the state
+func parseTestReport(state *State, body string) (map[uint]*ReportRecord, error) {
+	reader := csv.NewReader(strings.NewReader(body))
+	cols, err := checkReportHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	return getReportRecords(state, reader, cols)
+}
+
+// Checks rows as the response of the sales report, or of the event report of eventID if it is not 0,
+// expecting the reservations in state are all made before the request
+func checkTestReport(state *State, rows [][]string, eventID uint) error {
+	buf := &bytes.Buffer{}
+	csv.NewWriter(buf).WriteAll(rows)
+	timeBefore := time.Now()
+	if eventID == 0 {
+		return checkReportResponse(state, timeBefore, state.GetCopiedReservations())(&http.Response{}, buf)
+	}
+	return checkEventReportResponse(state, state.FindEventByID(eventID), timeBefore, state.GetCopiedReservationsInEventID(eventID))(&http.Response{}, buf)
+}
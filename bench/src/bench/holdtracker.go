@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracks users and administrators popped from State in DebugMode to find scenarios which forget to push them back.
+// Event sheets are not tracked because they are thrown away intentionally when reserve fails.
+
+type Hold struct {
+	Kind     string // "user" or "admin"
+	ID       uint
+	Scenario string
+	At       time.Time
+}
+
+func (h Hold) String() string {
+	return fmt.Sprintf("%s:%d held by %s for %s", h.Kind, h.ID, h.Scenario, time.Since(h.At).Round(time.Second))
+}
+
+type holdTracker struct {
+	mtx   sync.Mutex
+	holds map[string]Hold
+}
+
+func (t *holdTracker) init() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.holds = map[string]Hold{}
+}
+
+func (t *holdTracker) acquire(kind string, id uint) {
+	if !DebugMode {
+		return
+	}
+	h := Hold{Kind: kind, ID: id, Scenario: callerScenario(), At: time.Now()}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.holds[fmt.Sprintf("%s:%d", kind, id)] = h
+}
+
+func (t *holdTracker) release(kind string, id uint) {
+	if !DebugMode {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	delete(t.holds, fmt.Sprintf("%s:%d", kind, id))
+}
+
+// Returns the name of the nearest Check* or Load* function in the stack
+func callerScenario() string {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		name := strings.TrimPrefix(frame.Function, "bench.")
+		if strings.HasPrefix(name, "Check") || strings.HasPrefix(name, "Load") {
+			if i := strings.IndexByte(name, '.'); i >= 0 {
+				name = name[:i]
+			}
+			return name
+		}
+		if !more {
+			break
+		}
+	}
+	return "unknown"
+}
+
+// Returns holds older than the threshold, which are likely leaked. Only works in DebugMode.
+func (s *State) FindStaleHolds(threshold time.Duration) []Hold {
+	s.holds.mtx.Lock()
+	defer s.holds.mtx.Unlock()
+
+	var stale []Hold
+	for _, h := range s.holds.holds {
+		if time.Since(h.At) > threshold {
+			stale = append(stale, h)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].At.Before(stale[j].At) })
+	return stale
+}
+
+// Periodically logs holds older than the threshold until done is closed
+func (s *State) WatchStaleHolds(done <-chan struct{}, threshold time.Duration) {
+	if !DebugMode || threshold <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			for _, h := range s.FindStaleHolds(threshold) {
+				log.Printf("warn: possibly leaked hold %s\n", h)
+			}
+		}
+	}
+}
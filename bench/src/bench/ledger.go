@@ -0,0 +1,331 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ledger is a dump of events and reservations which the benchmarker believes the webapp has.
+// It is exported at the end of a run, and imported to run checks against the already-populated webapp
+// without redoing all reservations. This is only for developers of the benchmarker.
+
+const ledgerVersion = 1
+
+type ledger struct {
+	Version      int                 `json:"version"`
+	Users        []ledgerUser        `json:"users"`
+	Events       []ledgerEvent       `json:"events"`
+	Reservations []ledgerReservation `json:"reservations"`
+}
+
+type ledgerUser struct {
+	ID        uint   `json:"id"`
+	LoginName string `json:"login_name"`
+}
+
+type ledgerEvent struct {
+	ID       uint   `json:"id"`
+	Title    string `json:"title"`
+	PublicFg bool   `json:"public"`
+	ClosedFg bool   `json:"closed"`
+	Price    uint   `json:"price"`
+}
+
+type ledgerReservation struct {
+	ID                 uint      `json:"id"`
+	EventID            uint      `json:"event_id"`
+	UserID             uint      `json:"user_id"`
+	SheetRank          string    `json:"sheet_rank"`
+	SheetNum           uint      `json:"sheet_num"`
+	Price              uint      `json:"price"`
+	ReserveCompletedAt time.Time `json:"reserve_completed_at"`
+	CancelRequestedAt  time.Time `json:"cancel_requested_at"`
+	CancelCompletedAt  time.Time `json:"cancel_completed_at"`
+}
+
+// ExportLedger writes events and reservations of the state as JSON
+func ExportLedger(w io.Writer, state *State) error {
+	l := ledger{Version: ledgerVersion}
+
+	func() {
+		state.mtx.Lock()
+		defer state.mtx.Unlock()
+
+		for _, u := range state.userMap {
+			l.Users = append(l.Users, ledgerUser{u.ID, u.LoginName})
+		}
+		for _, e := range state.events {
+			l.Events = append(l.Events, ledgerEvent{e.ID, e.Title, e.PublicFg, e.ClosedFg, e.Price})
+		}
+	}()
+
+	for _, r := range state.GetCopiedReservations() {
+		l.Reservations = append(l.Reservations, ledgerReservation{
+			ID:                 r.ID,
+			EventID:            r.EventID,
+			UserID:             r.UserID,
+			SheetRank:          r.SheetRank,
+			SheetNum:           r.SheetNum,
+			Price:              r.Price,
+			ReserveCompletedAt: r.ReserveCompletedAt,
+			CancelRequestedAt:  r.CancelRequestedAt,
+			CancelCompletedAt:  r.CancelCompletedAt,
+		})
+	}
+
+	sort.Slice(l.Users, func(i, j int) bool { return l.Users[i].ID < l.Users[j].ID })
+	sort.Slice(l.Reservations, func(i, j int) bool { return l.Reservations[i].ID < l.Reservations[j].ID })
+
+	enc := json.NewEncoder(w)
+	return enc.Encode(&l)
+}
+
+// ImportLedger reads a ledger written by ExportLedger into the state.
+// The state must be just initialized by Init(), and the ledger must be consistent by itself.
+func ImportLedger(r io.Reader, state *State) error {
+	l := ledger{}
+	err := json.NewDecoder(r).Decode(&l)
+	if err != nil {
+		return fmt.Errorf("ledger: failed to decode: %v", err)
+	}
+	if l.Version != ledgerVersion {
+		return fmt.Errorf("ledger: unsupported version %d", l.Version)
+	}
+
+	err = validateLedger(&l)
+	if err != nil {
+		return err
+	}
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	state.reservationMtx.Lock()
+	defer state.reservationMtx.Unlock()
+
+	// Users created in the previous run are taken from newUsers
+	usersByID := map[uint]*AppUser{}
+	for _, u := range state.userMap {
+		usersByID[u.ID] = u
+	}
+	for _, lu := range l.Users {
+		if u, ok := usersByID[lu.ID]; ok {
+			if u.LoginName != lu.LoginName {
+				return fmt.Errorf("ledger: user %d has login name %s, expected %s", lu.ID, lu.LoginName, u.LoginName)
+			}
+			continue
+		}
+		found := false
+		for i, u := range state.newUsers {
+			if u.LoginName != lu.LoginName {
+				continue
+			}
+			state.newUsers = append(state.newUsers[:i], state.newUsers[i+1:]...)
+			u.ID = lu.ID
+			state.pushNewUserLocked(u)
+			usersByID[u.ID] = u
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("ledger: unknown user %s", lu.LoginName)
+		}
+	}
+
+	eventsByID := map[uint]*Event{}
+	for _, e := range state.events {
+		eventsByID[e.ID] = e
+	}
+	for _, le := range l.Events {
+		if e, ok := eventsByID[le.ID]; ok {
+			if e.Title != le.Title || e.Price != le.Price || e.PublicFg != le.PublicFg || e.ClosedFg != le.ClosedFg {
+				return fmt.Errorf("ledger: event %d differs from the initial data set", le.ID)
+			}
+			continue
+		}
+		event := &Event{ID: le.ID, Title: le.Title, PublicFg: le.PublicFg, ClosedFg: le.ClosedFg, Price: le.Price}
+		state.pushNewEventLocked(event, time.Time{}, "ImportLedger")
+		eventsByID[event.ID] = event
+	}
+
+	cancel := func(event *Event, reservation *Reservation, lr ledgerReservation) {
+		reservation.CancelRequestedAt = lr.CancelRequestedAt
+		reservation.CancelCompletedAt = lr.CancelCompletedAt
+		state.cancelRequestedCount++
+		state.cancelCompletedCount++
+		event.CancelRequestedCount++
+		event.CancelCompletedCount++
+		*event.CancelRequestedRT.getPointer(lr.SheetRank)++
+		*event.CancelCompletedRT.getPointer(lr.SheetRank)++
+	}
+
+	for _, lr := range l.Reservations {
+		event, ok := eventsByID[lr.EventID]
+		if !ok {
+			return fmt.Errorf("ledger: reservation %d refers unknown event %d", lr.ID, lr.EventID)
+		}
+		user, ok := usersByID[lr.UserID]
+		if !ok {
+			return fmt.Errorf("ledger: reservation %d refers unknown user %d", lr.ID, lr.UserID)
+		}
+
+		if reservation, ok := state.reservations[lr.ID]; ok {
+			// Initial reservations may have been canceled in the previous run
+			if !reservation.CancelCompletedAt.IsZero() {
+				if lr.CancelCompletedAt.IsZero() {
+					return fmt.Errorf("ledger: initial reservation %d is canceled in the initial data set", lr.ID)
+				}
+				continue
+			}
+			if !lr.CancelCompletedAt.IsZero() {
+				cancel(event, reservation, lr)
+				user.Status.PositiveTotalPrice -= reservation.Price
+				user.Status.NegativeTotalPrice -= reservation.Price
+			}
+			continue
+		}
+
+		reservation := &Reservation{
+			ID:                 lr.ID,
+			EventID:            lr.EventID,
+			UserID:             lr.UserID,
+			SheetRank:          lr.SheetRank,
+			SheetNum:           lr.SheetNum,
+			Price:              lr.Price,
+			ReserveCompletedAt: lr.ReserveCompletedAt,
+			CancelRequestedAt:  lr.CancelRequestedAt,
+			CancelCompletedAt:  lr.CancelCompletedAt,
+		}
+		state.reservations[reservation.ID] = reservation
+		state.reserveRequestedCount++
+		state.reserveCompletedCount++
+
+		event.ReserveRequestedCount++
+		event.ReserveCompletedCount++
+		*event.ReserveRequestedRT.getPointer(lr.SheetRank)++
+		*event.ReserveCompletedRT.getPointer(lr.SheetRank)++
+		user.Status.LastReservedEvent.SetIDWithTime(lr.EventID, lr.ReserveCompletedAt)
+		user.Status.LastMaybeReservedEvent.SetIDWithTime(lr.EventID, lr.ReserveCompletedAt)
+		user.Status.LastReservation.SetIDWithTime(lr.ID, lr.ReserveCompletedAt)
+		user.Status.LastMaybeReservation.SetIDWithTime(lr.ID, lr.ReserveCompletedAt)
+
+		if !lr.CancelCompletedAt.IsZero() {
+			cancel(event, reservation, lr)
+			continue
+		}
+
+		user.Status.PositiveTotalPrice += lr.Price
+		user.Status.NegativeTotalPrice += lr.Price
+		state.takeEventSheetLocked(lr.EventID, lr.SheetRank, lr.SheetNum)
+	}
+
+	return nil
+}
+
+// Moves a non-reserved sheet of the event into reservedEventSheets
+func (s *State) takeEventSheetLocked(eventID uint, rank string, num uint) {
+	for _, sheets := range []*[]*EventSheet{&s.eventSheets, &s.privateEventSheets, &s.closedEventSheets} {
+		for i, eventSheet := range *sheets {
+			if eventSheet.EventID != eventID || eventSheet.Rank != rank {
+				continue
+			}
+			*sheets = append((*sheets)[:i], (*sheets)[i+1:]...)
+			eventSheet.Num = num
+			s.reservedEventSheets = append(s.reservedEventSheets, eventSheet)
+			return
+		}
+	}
+}
+
+func validateLedger(l *ledger) error {
+	userIDs := map[uint]bool{}
+	for _, u := range l.Users {
+		if u.ID == 0 || userIDs[u.ID] {
+			return fmt.Errorf("ledger: invalid or duplicated user id %d", u.ID)
+		}
+		userIDs[u.ID] = true
+	}
+
+	events := map[uint]ledgerEvent{}
+	for _, e := range l.Events {
+		if _, ok := events[e.ID]; ok || e.ID == 0 {
+			return fmt.Errorf("ledger: invalid or duplicated event id %d", e.ID)
+		}
+		if e.PublicFg && e.ClosedFg {
+			return fmt.Errorf("ledger: event %d is public and closed", e.ID)
+		}
+		events[e.ID] = e
+	}
+
+	sheetKey := func(eventID uint, rank string, num uint) string {
+		return strconv.Itoa(int(eventID)) + "-" + rank + "-" + strconv.Itoa(int(num))
+	}
+
+	initials := map[uint]*Reservation{}
+	for _, r := range DataSet.Reservations {
+		initials[r.ID] = r
+	}
+
+	reservationIDs := map[uint]bool{}
+	for _, r := range l.Reservations {
+		if r.ID == 0 || reservationIDs[r.ID] {
+			return fmt.Errorf("ledger: invalid or duplicated reservation id %d", r.ID)
+		}
+		reservationIDs[r.ID] = true
+
+		if initial, ok := initials[r.ID]; ok {
+			if r.EventID != initial.EventID || r.UserID != initial.UserID || sheetKey(r.EventID, r.SheetRank, r.SheetNum) != sheetKey(initial.EventID, initial.SheetRank, initial.SheetNum) {
+				return fmt.Errorf("ledger: reservation %d differs from the initial data set", r.ID)
+			}
+		}
+	}
+
+	// Sheets of initial reservations not in the ledger are kept reserved
+	owners := map[string]uint{}
+	for _, r := range DataSet.Reservations {
+		if reservationIDs[r.ID] || r.CanceledAt != 0 {
+			continue
+		}
+		owners[sheetKey(r.EventID, r.SheetRank, r.SheetNum)] = r.ID
+	}
+
+	for _, r := range l.Reservations {
+
+		if !userIDs[r.UserID] {
+			return fmt.Errorf("ledger: reservation %d refers unknown user %d", r.ID, r.UserID)
+		}
+		event, ok := events[r.EventID]
+		if !ok {
+			return fmt.Errorf("ledger: reservation %d refers unknown event %d", r.ID, r.EventID)
+		}
+		sheetKind, ok := DataSet.SheetKindMap[r.SheetRank]
+		if !ok {
+			return fmt.Errorf("ledger: reservation %d has invalid rank %s", r.ID, r.SheetRank)
+		}
+		if r.SheetNum < 1 || sheetKind.Total < r.SheetNum {
+			return fmt.Errorf("ledger: reservation %d has invalid sheet num %d", r.ID, r.SheetNum)
+		}
+		if r.Price != event.Price+sheetKind.Price {
+			return fmt.Errorf("ledger: reservation %d has price %d, expected %d", r.ID, r.Price, event.Price+sheetKind.Price)
+		}
+
+		// A cancel whose response has not been received is ambiguous
+		if r.CancelRequestedAt.IsZero() != r.CancelCompletedAt.IsZero() {
+			return fmt.Errorf("ledger: reservation %d has an unfinished cancelation", r.ID)
+		}
+		if !r.CancelCompletedAt.IsZero() {
+			continue
+		}
+
+		key := sheetKey(r.EventID, r.SheetRank, r.SheetNum)
+		if other, ok := owners[key]; ok {
+			return fmt.Errorf("ledger: reservation %d and %d have the same sheet %s", other, r.ID, key)
+		}
+		owners[key] = r.ID
+	}
+
+	return nil
+}
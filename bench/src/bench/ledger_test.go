@@ -0,0 +1,115 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sets up a fresh data set of 2 users, 1 event and 2 initial reservations, one of which is canceled
+func setupLedgerDataSet() func() {
+	orig := DataSet
+	now := time.Now().Unix()
+
+	DataSet.Users = []*AppUser{
+		{ID: 1, Nickname: "u1", LoginName: "u1", Password: "u1"},
+		{ID: 2, Nickname: "u2", LoginName: "u2", Password: "u2"},
+	}
+	DataSet.NewUsers = nil
+	DataSet.Administrators = nil
+	DataSet.Events = []*Event{{ID: 1, Title: "e1", PublicFg: true, Price: 1000}}
+	DataSet.ClosedEvents = nil
+	DataSet.Reservations = []*Reservation{
+		{ID: 1, EventID: 1, UserID: 1, SheetRank: "S", SheetNum: 1, Price: 6000, ReservedAt: now - 20},
+		{ID: 2, EventID: 1, UserID: 2, SheetRank: "S", SheetNum: 2, Price: 6000, ReservedAt: now - 20, CanceledAt: now - 10},
+	}
+	for _, r := range DataSet.Reservations {
+		r.ReserveCompletedAt = time.Unix(r.ReservedAt, 0)
+		if r.CanceledAt != 0 {
+			r.CancelRequestedAt = time.Unix(r.CanceledAt, 0)
+			r.CancelCompletedAt = time.Unix(r.CanceledAt, 0)
+		} else {
+			user := DataSet.Users[r.UserID-1]
+			user.Status.PositiveTotalPrice += r.Price
+			user.Status.NegativeTotalPrice += r.Price
+		}
+	}
+
+	return func() { DataSet = orig }
+}
+
+func cancelTestReservation(state *State, user *AppUser, reservation *Reservation) {
+	logID := state.BeginCancelation(user, reservation)
+	state.CommitCancelation(logID, user, reservation)
+}
+
+func TestLedgerRoundTrip(t *testing.T) {
+	defer setupLedgerDataSet()()
+	exported := newTestState()
+	u1, u2 := DataSet.Users[0], DataSet.Users[1]
+
+	// Cancel the initial reservation, reserve 2 sheets and cancel one of them
+	cancelTestReservation(exported, u1, exported.reservations[1])
+	addTestReservation(exported, u1, &Reservation{ID: 3, EventID: 1, UserID: u1.ID, SheetRank: "A", SheetNum: 1, Price: 4000})
+	r4 := addTestReservation(exported, u2, &Reservation{ID: 4, EventID: 1, UserID: u2.ID, SheetRank: "B", SheetNum: 1, Price: 2000})
+	cancelTestReservation(exported, u2, r4)
+	totals := []uint{u1.Status.PositiveTotalPrice, u2.Status.PositiveTotalPrice}
+
+	buf := &bytes.Buffer{}
+	if err := ExportLedger(buf, exported); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setupLedgerDataSet()()
+	imported := newTestState()
+	if err := ImportLedger(buf, imported); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := imported.GetBookingCounts(), exported.GetBookingCounts(); got != want {
+		t.Errorf("booking counts differ: imported %+v, exported %+v", got, want)
+	}
+	for i, user := range DataSet.Users {
+		if user.Status.PositiveTotalPrice != totals[i] || user.Status.NegativeTotalPrice != totals[i] {
+			t.Errorf("user %d has total price %s, expected %d", user.ID, user.Status.TotalPriceString(), totals[i])
+		}
+	}
+	for id, want := range exported.GetCopiedReservations() {
+		got, ok := imported.GetCopiedReservations()[id]
+		if !ok {
+			t.Errorf("reservation %d is not imported", id)
+			continue
+		}
+		if !got.CancelCompletedAt.Equal(want.CancelCompletedAt) || !got.ReserveCompletedAt.Equal(want.ReserveCompletedAt) {
+			t.Errorf("reservation %d differs: imported %+v, exported %+v", id, got, want)
+		}
+	}
+	event := imported.FindEventByID(1)
+	if event.CancelCompletedCount != 2 || event.ReserveCompletedCount != 2 {
+		t.Errorf("unexpected event counts: reserve %d cancel %d", event.ReserveCompletedCount, event.CancelCompletedCount)
+	}
+}
+
+func TestLedgerRejectsSheetOfInitialReservation(t *testing.T) {
+	defer setupLedgerDataSet()()
+	state := newTestState()
+
+	// The initial reservation 1 of S-1 is not in the ledger, and is still active
+	l := ledger{
+		Version: ledgerVersion,
+		Users:   []ledgerUser{{1, "u1"}, {2, "u2"}},
+		Events:  []ledgerEvent{{1, "e1", true, false, 1000}},
+		Reservations: []ledgerReservation{
+			{ID: 3, EventID: 1, UserID: 2, SheetRank: "S", SheetNum: 1, Price: 6000, ReserveCompletedAt: time.Now()},
+		},
+	}
+	buf := &bytes.Buffer{}
+	json.NewEncoder(buf).Encode(&l)
+
+	err := ImportLedger(buf, state)
+	if err == nil || !strings.Contains(err.Error(), "same sheet") {
+		t.Errorf("expected a duplicated sheet error, got %v", err)
+	}
+}
@@ -16,6 +16,7 @@ var (
 	PostTimeout           = 3 * time.Second
 	DeleteTimeout         = 3 * time.Second
 	InitializeTimeout     = 10 * time.Second
+	ConnectTimeout        = 3 * time.Second // bounds establishing a connection, separately from the timeouts of whole requests above
 	SlowThreshold         = 1000 * time.Millisecond
 	MaxCheckerRequest     = 6
 	PostTestLoginTimeout  = 20 * time.Second // postTest takes time because of remained requests. This value was tuned to pass initial app
@@ -30,6 +31,75 @@ var (
 	EveryCheckerInterval     = 3 * time.Second
 	AllowableDelay           = time.Second
 	WaitOnError              = 500 * time.Millisecond
+	FailFastOnServerError    = false // fail the benchmark immediately on a 5xx response during load
+
+	ShutdownGracePeriod = 5 * time.Second // how long to wait for in-flight requests after SIGINT or SIGTERM
+
+	SkipStaticHash = false // skip md5 of static files in CheckStaticFiles for performance runs (status codes are still checked)
+
+	AssetConcurrency            = 64 // # of concurrent requests to load assets shared by all pages
+	LoadChurnMaxCancels         = 3  // max # of old reservations canceled by one LoadReservationChurn
+	MaxConcurrentEventCreations = 1  // # of events popOrCreateEventSheet can create at the same time
+	ManyCancelsReservations     = 10 // # of reservations made by CheckEventReportManyCancels
+	ManyCancelsRemains          = 2  // # of reservations left not canceled by CheckEventReportManyCancels
+	ReserveBurstCount           = 10 // # of reservations a user makes back-to-back in reserve burst scenarios
+	ReportManyUsersCount        = 5  // # of distinct users who reserve in CheckReportManyUsers
+
+	ReserveRateWindow = 10 * time.Second // sliding window to measure reservations per second of each event
+	ReserveRateTopN   = 5                // # of the hottest events shown in the summary
+
+	WarmupDuration = 0 * time.Second // load runs but is not scored for this duration at the beginning of load, 0 to disable
+
+	StaleHoldThreshold = 30 * time.Second // a user or an administrator popped longer than it is reported as leaked in debug mode
+
+	ReportSoldAtBackwardsThreshold = time.Second // warn when sold_at of a report row is earlier than the one of the previous reservation_id by more than it, 0 to disable
+
+	UserZipfS = 0.0 // s (> 1) of Zipf distribution to favor power users with smaller IDs in PopRandomUser, 0 for uniform
+
+	MaxAdministrators       = 0     // # of administrators used by the benchmarker from the head of admin.tsv, 0 for all
+	AdministratorRoundRobin = false // PopRandomAdministrator returns the administrator idle for the longest time instead of a random one
+
+	UserAgents = []string{} // User-Agents rotated over checkers to simulate diverse clients, empty to use bench.UserAgent only
+
+	SessionCookieNames = []string{"torb_session", "session"} // cookies checked by StrictSessionCookie, torb_session of Perl, Ruby and PHP and session of Go and Python
+
+	DashboardPort        = 0     // port of the live status page of benchmarker, 0 to disable
+	EnableBenchRequestID = false // add X-Bench-Request-ID header to correlate with webapp logs
+
+	LoadMaxRetries   = 0                      // # of retries of GET requests in load scenarios on network errors
+	LoadRetryBackoff = 100 * time.Millisecond // backoff before the first retry, doubled on each retry
+
+	ServerHeaderSampleRate = 100   // check 1 in N responses for Server or X-Powered-By with a version, 0 to disable
+	StrictServerHeader     = false // fail instead of counting when a response discloses the server version
+
+	ReservationPriceSampleRate = 100 // check 1 in N reservations without price in the response by getting the event, 0 to disable
+
+	OversizedRequestBodySize    = 0               // bytes of the body posted by CheckOversizedRequestBody, 0 to disable
+	OversizedRankLength         = 0               // length of sheet_rank posted by CheckReserveOversizedRank, 0 to disable
+	ReportLockCheckDuration     = 0 * time.Second // duration of CheckReportLockContention, 0 to disable
+	ReserveCancelRaceIterations = 5               // # of races between cancel and reserve on the same sheet in CheckReserveCancelRace, 0 to disable (each run also reserves all the S sheets of a new event)
+	StaleReservationCheckWait   = 0 * time.Second // how long CheckReservationNotExpired leaves a reservation untouched, 0 to disable
+	ConcurrentReserveUsers      = 5               // # of users reserving the same rank at the same time in CheckConcurrentReserve
+	ConcurrentReserveRounds     = 3               // # of reservations each user makes in CheckConcurrentReserve
+	EventCapCheckCount          = 3               // # of events created in a row by CheckEventCap
+
+	// Expected behaviors of the webapp which are not explicitly defined in the manual
+	CloseFreezesCancel              = true  // closed event rejects cancelation (invalid_event) like the reference implementation
+	MyPageListsCanceledReservations = true  // recent_reservations of my page contains canceled ones with canceled_at
+	MultipleSessionsAllowed         = true  // a user can log in from multiple clients at the same time (the reference implementation uses cookie sessions)
+	LogoutInvalidatesSession        = false // a session cookie copied before logout is rejected after it (the reference implementation uses cookie sessions)
+	MyPageAllowsOtherUsers          = false // GET /api/users/{id} of another user returns the user instead of 403
+	EventCap                        = 0     // maximum # of events the webapp allows, creating beyond it returns 400, 0 for no cap
+	EditRejectsInvalidPublic        = false // edit with non-boolean or missing public returns 400 (the reference implementation ignores it)
+	ReportColumnsByName             = false // report columns are matched by the header names in any order, not by the fixed order
+	StrictSessionCookie             = false // cookies set by login must be HttpOnly with Path=/ (the reference implementation does not set HttpOnly)
+	StrictReportOrder               = false // report rows must be sorted by reservation_id (the reference implementation sorts them by sold_at)
+	StrictReportReservations        = false // every reservation_id of reports must be made by the benchmarker, tolerating as many as unfinished reserve requests
+	StrictReportUTC                 = false // sold_at and canceled_at of reports must be in UTC (Z suffix), not only valid RFC3339
+	ReportRejectsNegativeEventID    = false // event report with a negative id returns 404 (the reference implementation returns 500)
+	ReserveTrailingSlash            = ""    // "accept" or "reject" (404) for the reserve path with a trailing slash, "" to skip
+	SessionSurvivesRestart          = true  // sessions are still valid after the webapp restarts (the reference implementation uses cookie sessions)
+	SheetRankCaseHandling           = ""    // "reject" or "normalize" for a lowercase or space padded rank, "" to skip (the reference implementation depends on MySQL collation)
 
 	Score = func(getCount int64, postCount int64, deleteCount int64, staticCount int64, reserveCount int64, cancelCount int64, topCount int64, getEventCount int64) int64 {
 		return 1*(getCount-staticCount-topCount-getEventCount) + 1*(postCount-reserveCount) + 5*(topCount+getEventCount) + 10*(reserveCount+cancelCount) + staticCount/100
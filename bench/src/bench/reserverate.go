@@ -0,0 +1,122 @@
+package bench
+
+import (
+	"bench/parameter"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tracks reservations per second of each event over a sliding window to see whether the load concentrates on a few events.
+// Only the top-N peak rates are kept for the summary, and windows of idle events are thrown away.
+
+type EventReserveRate struct {
+	EventID uint
+	Rate    float64 // reservations per second
+	At      time.Time
+}
+
+type reserveRateWindow struct {
+	secs   []int64
+	counts []int64
+	last   int64
+}
+
+func (w *reserveRateWindow) add(sec int64) {
+	i := int(sec % int64(len(w.secs)))
+	if w.secs[i] != sec {
+		w.secs[i] = sec
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+	w.last = sec
+}
+
+func (w *reserveRateWindow) rate(sec int64) float64 {
+	var sum int64
+	n := int64(len(w.secs))
+	for i, s := range w.secs {
+		if sec-n < s && s <= sec {
+			sum += w.counts[i]
+		}
+	}
+	return float64(sum) / float64(n)
+}
+
+type reserveRateTracker struct {
+	mtx       sync.Mutex
+	windows   map[uint]*reserveRateWindow
+	peaks     []EventReserveRate // sorted by Rate desc
+	lastPrune int64
+}
+
+func (t *reserveRateTracker) init() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.windows = map[uint]*reserveRateWindow{}
+	t.peaks = nil
+}
+
+func (t *reserveRateTracker) record(eventID uint, now time.Time) {
+	size := int(parameter.ReserveRateWindow / time.Second)
+	if size < 1 {
+		size = 1
+	}
+	sec := now.Unix()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	w, ok := t.windows[eventID]
+	if !ok {
+		w = &reserveRateWindow{secs: make([]int64, size), counts: make([]int64, size)}
+		t.windows[eventID] = w
+	}
+	w.add(sec)
+	t.updatePeakLocked(EventReserveRate{eventID, w.rate(sec), now})
+
+	if t.lastPrune != sec {
+		t.lastPrune = sec
+		for id, w := range t.windows {
+			if w.last <= sec-int64(size) {
+				delete(t.windows, id)
+			}
+		}
+	}
+}
+
+func (t *reserveRateTracker) updatePeakLocked(r EventReserveRate) {
+	for i, p := range t.peaks {
+		if p.EventID == r.EventID {
+			if r.Rate <= p.Rate {
+				return
+			}
+			t.peaks = append(t.peaks[:i], t.peaks[i+1:]...)
+			break
+		}
+	}
+
+	n := parameter.ReserveRateTopN
+	if n <= 0 {
+		return
+	}
+	if len(t.peaks) >= n && r.Rate <= t.peaks[len(t.peaks)-1].Rate {
+		return
+	}
+	t.peaks = append(t.peaks, r)
+	sort.SliceStable(t.peaks, func(i, j int) bool { return t.peaks[i].Rate > t.peaks[j].Rate })
+	if len(t.peaks) > n {
+		t.peaks = t.peaks[:n]
+	}
+}
+
+// Returns events which had the highest reservation rates during the benchmark
+func (s *State) GetHottestEvents() []EventReserveRate {
+	s.reserveRate.mtx.Lock()
+	defer s.reserveRate.mtx.Unlock()
+
+	peaks := make([]EventReserveRate, len(s.reserveRate.peaks))
+	copy(peaks, s.reserveRate.peaks)
+	return peaks
+}
@@ -0,0 +1,63 @@
+package bench
+
+import (
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Keeps a session captured before the webapp is restarted by the operator, for CheckSessionAcrossRestart.
+// The benchmarker cannot restart the webapp, so it is told by NotifyServerRestarted, e.g., on SIGUSR1.
+
+type restartSession struct {
+	user       *AppUser
+	generation uint64
+	cookies    []*http.Cookie
+}
+
+type restartSessionTracker struct {
+	mtx        sync.Mutex
+	generation uint64 // incremented on each restart
+	session    *restartSession
+	capturing  bool
+}
+
+// Returns the captured session and forgets it if the webapp has been restarted since it was captured.
+// Otherwise capture is true if the caller should capture a new session of the returned generation and pass it to store.
+func (t *restartSessionTracker) take() (session *restartSession, generation uint64, capture bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.session == nil {
+		if t.capturing {
+			return nil, t.generation, false
+		}
+		t.capturing = true
+		return nil, t.generation, true
+	}
+	if t.session.generation == t.generation {
+		return nil, t.generation, false
+	}
+
+	session = t.session
+	t.session = nil
+	return session, t.generation, false
+}
+
+// Ends the capture started by take. session is nil if the capture failed.
+func (t *restartSessionTracker) store(session *restartSession) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.session = session
+	t.capturing = false
+}
+
+// Marks the boundary that the webapp has been restarted
+func (s *State) NotifyServerRestarted() {
+	s.restartSession.mtx.Lock()
+	defer s.restartSession.mtx.Unlock()
+
+	s.restartSession.generation++
+	log.Println("info: the webapp has been restarted")
+}
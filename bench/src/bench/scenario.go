@@ -5,9 +5,7 @@ import (
 	"bench/parameter"
 	"bytes"
 	"context"
-	"crypto/md5"
 	"encoding/csv"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"hash"
@@ -20,6 +18,8 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -44,14 +44,20 @@ func checkRedirectStatusCode(res *http.Response, body *bytes.Buffer) error {
 	return fmt.Errorf("期待していないステータスコード %d Expected 302 or 303", res.StatusCode)
 }
 
+// A response with 204 No Content must not have a body
+func checkEmptyBody(res *http.Response, body *bytes.Buffer) error {
+	if body.Len() != 0 || res.ContentLength > 0 {
+		return fatalErrorf("204のレスポンスにボディが含まれています")
+	}
+	return nil
+}
+
 func checkJsonErrorResponse(errorCode string) func(res *http.Response, body *bytes.Buffer) error {
 	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
 		jsonError := JsonError{}
-		dec := json.NewDecoder(body)
-		err := dec.Decode(&jsonError)
+		err := decodeJSON(body, &jsonError)
 		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
+			return err
 		}
 		if jsonError.Error != errorCode {
 			return fatalErrorf("正しいエラーコードを取得できません %s", jsonError.Error)
@@ -60,6 +66,31 @@ func checkJsonErrorResponse(errorCode string) func(res *http.Response, body *byt
 	}
 }
 
+// Checks remains is in the range which the reservations in-flight between the request and the response can explain
+func checkRemains(
+	eventID uint,
+	total uint,
+	cancelCompletedCountBeforeRequest uint,
+	reserveRequestedCountAfterResponse uint,
+	remains uint,
+	cancelRequestedCountAfterResponse uint,
+	reserveCompletedCountBeforeResponse uint) error {
+	log.Printf("debug: EventID:%d total:%d+cancelCompletedCountBeforeRequest:%d-reserveRequestedCountAfterResponse:%d <= remains:%d <= total:%d+cancelRequestedCountAfterResponse:%d-reserveCompletedCountBeforeResponse:%d",
+		eventID,
+		total,
+		cancelCompletedCountBeforeRequest,
+		reserveRequestedCountAfterResponse,
+		remains,
+		total,
+		cancelRequestedCountAfterResponse,
+		reserveCompletedCountBeforeResponse)
+	if int32(total)+int32(cancelCompletedCountBeforeRequest)-int32(reserveRequestedCountAfterResponse) <= int32(remains) &&
+		int32(remains) <= int32(total)+int32(cancelRequestedCountAfterResponse)-int32(reserveCompletedCountBeforeResponse) {
+		return nil
+	}
+	return &fatalError{}
+}
+
 func checkEventList(state *State, eventsBeforeRequest []*Event, events []JsonEvent, eventsAfterResponse []*Event) error {
 	eventsMap := map[uint]JsonEvent{}
 	for _, e := range events {
@@ -73,34 +104,13 @@ func checkEventList(state *State, eventsBeforeRequest []*Event, events []JsonEve
 
 	msg := "正しいイベント一覧を取得できません"
 
-	checkRemains := func(
-		eventID uint,
-		total uint,
-		cancelCompletedCountBeforeRequest uint,
-		reserveRequestedCountAfterResponse uint,
-		remains uint,
-		cancelRequestedCountAfterResponse uint,
-		reserveCompletedCountBeforeResponse uint) error {
-		log.Printf("debug: EventID:%d total:%d+cancelCompletedCountBeforeRequest:%d-reserveRequestedCountAfterResponse:%d <= remains:%d <= total:%d+cancelRequestedCountAfterResponse:%d-reserveCompletedCountBeforeResponse:%d",
-			eventID,
-			total,
-			cancelCompletedCountBeforeRequest,
-			reserveRequestedCountAfterResponse,
-			remains,
-			total,
-			cancelRequestedCountAfterResponse,
-			reserveCompletedCountBeforeResponse)
-		if int32(total)+int32(cancelCompletedCountBeforeRequest)-int32(reserveRequestedCountAfterResponse) <= int32(remains) &&
-			int32(remains) <= int32(total)+int32(cancelRequestedCountAfterResponse)-int32(reserveCompletedCountBeforeResponse) {
-			return nil
-		}
-		return &fatalError{}
-	}
-
+	// NOTE: Events whose creation failed or timeouted are never pushed into State.events,
+	// so title, total and per-rank total are checked only for events certainly created.
+	// Events in the response unknown to the benchmarker are just ignored.
 	for _, eventBeforeRequest := range eventsBeforeRequest {
 		e, ok := eventsMap[eventBeforeRequest.ID]
 		if !ok {
-			log.Printf("debug: checkEventList: should exist (eventID:%d)\n", e.ID)
+			log.Printf("debug: checkEventList: should exist (eventID:%d)\n", eventBeforeRequest.ID)
 			return fatalErrorf(msg)
 		}
 		if e.Title != eventBeforeRequest.Title {
@@ -171,15 +181,13 @@ func checkEventList(state *State, eventsBeforeRequest []*Event, events []JsonEve
 	return nil
 }
 
-func checkJsonFullUserResponse(user *AppUser, check func(*JsonFullUser) error) func(res *http.Response, body *bytes.Buffer) error {
+// timeBefore is the time before which reserve/cancel operations of the user must be visible
+func checkJsonFullUserResponse(state *State, user *AppUser, timeBefore time.Time, check func(*JsonFullUser) error) func(res *http.Response, body *bytes.Buffer) error {
 	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
-
-		var v JsonFullUser
-		err := dec.Decode(&v)
+		v := JsonFullUser{}
+		err := decodeJSON(body, &v)
 		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
+			return err
 		}
 		if user.ID != v.ID {
 			log.Printf("warn: expected id=%d but got id=%d\n", user.ID, v.ID)
@@ -205,6 +213,81 @@ func checkJsonFullUserResponse(user *AppUser, check func(*JsonFullUser) error) f
 			}
 		}
 
+		// Optional metadata must be sane if exists
+		for name, raw := range map[string]json.RawMessage{"last_login": v.LastLogin, "created_at": v.CreatedAt} {
+			t, ok, err := parseOptionalTimestamp(raw)
+			if err != nil {
+				log.Printf("warn: invalid %s=%s userID=%d error:%v\n", name, string(raw), v.ID, err)
+				return fatalErrorf("ユーザー情報の%sの形式が正しくありません userID=%d", name, v.ID)
+			}
+			if ok && t.After(time.Now().Add(parameter.AllowableDelay)) {
+				return fatalErrorf("ユーザー情報の%sが未来の時刻です userID=%d", name, v.ID)
+			}
+		}
+
+		// Canceled reservations may be listed with canceled_at, but must not be counted in total_price
+		reservations := state.GetCopiedReservationsInUserID(user.ID)
+		var activePrice uint
+		for _, r := range v.RecentReservations {
+			canceled := false
+			if reservation, ok := reservations[r.ReservationID]; ok {
+				canceled = !reservation.CancelCompletedAt.IsZero() && reservation.CancelCompletedAt.Before(timeBefore)
+			}
+			if canceled || r.CanceledAt != 0 {
+				if !parameter.MyPageListsCanceledReservations {
+					return fatalErrorf("最近予約した席にキャンセルした席が含まれています userID=%d", v.ID)
+				}
+				if r.CanceledAt == 0 {
+					log.Printf("warn: canceled reservation is listed without canceled_at userID=%d reservationID=%d\n", v.ID, r.ReservationID)
+					return fatalErrorf("最近予約した席のキャンセル状態が正しくありません userID=%d reservationID=%d", v.ID, r.ReservationID)
+				}
+				continue
+			}
+			activePrice += r.Price
+		}
+		if v.TotalPrice < activePrice {
+			log.Printf("warn: total price=%d is less than active recent reservations price=%d userID=%d\n", v.TotalPrice, activePrice, v.ID)
+			return fatalErrorf("予約総額が正しくありません userID=%d", v.ID)
+		}
+
+		// Canceled ones must be excluded from total_price, i.e. it must not exceed the price of reservations not canceled yet
+		var maybeActivePrice uint
+		for _, reservation := range reservations {
+			if reservation.CancelCompletedAt.IsZero() || !reservation.CancelCompletedAt.Before(timeBefore) {
+				maybeActivePrice += reservation.Price
+			}
+		}
+		if v.TotalPrice > maybeActivePrice+user.Status.PositiveTotalPrice-user.Status.NegativeTotalPrice {
+			log.Printf("warn: total price=%d exceeds price of not canceled reservations=%d userID=%d\n", v.TotalPrice, maybeActivePrice, v.ID)
+			return fatalErrorf("予約総額にキャンセルした席が含まれています userID=%d", v.ID)
+		}
+
+		// Reservations settled before the allowable delay must be reflected
+		var settledPrice uint
+		settledCount := 0
+		settledEvents := map[uint]struct{}{}
+		for _, reservation := range reservations {
+			if reservation.ReserveCompletedAt.IsZero() || !reservation.ReserveCompletedAt.Before(timeBefore) {
+				continue
+			}
+			active := reservation.CancelRequestedAt.IsZero()
+			if active {
+				settledPrice += reservation.Price
+				settledEvents[reservation.EventID] = struct{}{}
+			}
+			if active || parameter.MyPageListsCanceledReservations {
+				settledCount++
+			}
+		}
+		if v.TotalPrice < settledPrice {
+			log.Printf("warn: total price=%d is less than price of settled reservations=%d userID=%d\n", v.TotalPrice, settledPrice, v.ID)
+			return fatalErrorf("予約総額に予約した席が含まれていません userID=%d", v.ID)
+		}
+		if len(v.RecentReservations) < settledCount && len(v.RecentReservations) < 5 {
+			log.Printf("warn: %d recent reservations are listed but %d are settled userID=%d\n", len(v.RecentReservations), settledCount, v.ID)
+			return fatalErrorf("最近予約した席が不足しています userID=%d", v.ID)
+		}
+
 		// basic checks for RecentEvents
 		if v.RecentEvents == nil {
 			return fatalErrorf("最近予約したイベントを取得できません")
@@ -217,6 +300,10 @@ func checkJsonFullUserResponse(user *AppUser, check func(*JsonFullUser) error) f
 				return fatalErrorf("最近予約したイベントがnullです")
 			}
 		}
+		if len(v.RecentEvents) < len(settledEvents) && len(v.RecentEvents) < 5 {
+			log.Printf("warn: %d recent events are listed but %d are settled userID=%d\n", len(v.RecentEvents), len(settledEvents), v.ID)
+			return fatalErrorf("最近予約したイベントが不足しています userID=%d", v.ID)
+		}
 
 		return check(&v)
 	}
@@ -225,6 +312,7 @@ func checkJsonFullUserResponse(user *AppUser, check func(*JsonFullUser) error) f
 func loadStaticFile(ctx context.Context, checker *Checker, path string) error {
 	return checker.Play(ctx, &CheckAction{
 		EnableCache: true,
+		EnableGzip:  true,
 
 		Method: "GET",
 		Path:   path,
@@ -232,8 +320,10 @@ func loadStaticFile(ctx context.Context, checker *Checker, path string) error {
 			// Note. EnableCache時はPlay時に自動でReponseは最後まで読まれる
 			if res.StatusCode == http.StatusOK {
 				counter.IncKey("staticfile-200")
+				counter.IncKey("asset|200|" + path)
 			} else if res.StatusCode == http.StatusNotModified {
 				counter.IncKey("staticfile-304")
+				counter.IncKey("asset|304|" + path)
 			} else {
 				return fmt.Errorf("期待していないステータスコード %d", res.StatusCode)
 			}
@@ -242,9 +332,80 @@ func loadStaticFile(ctx context.Context, checker *Checker, path string) error {
 	})
 }
 
+type assetJob struct {
+	ctx     context.Context
+	checker *Checker
+	path    string
+}
+
+var (
+	assetJobs     chan assetJob
+	assetStop     chan struct{}
+	assetWorkers  sync.WaitGroup
+	assetPoolOnce sync.Once
+)
+
+// Workers shared by all pages so that asset bursts do not overwhelm the benchmarker itself
+func startAssetPool() {
+	assetJobs = make(chan assetJob, parameter.AssetConcurrency*8)
+	assetStop = make(chan struct{})
+	for i := 0; i < parameter.AssetConcurrency; i++ {
+		assetWorkers.Add(1)
+		go func() {
+			defer assetWorkers.Done()
+			for {
+				var job assetJob
+				select {
+				case job = <-assetJobs:
+				case <-assetStop:
+					return
+				}
+				if job.ctx.Err() != nil {
+					continue
+				}
+				err := loadStaticFile(job.ctx, job.checker, job.path)
+				if err != nil && job.ctx.Err() == nil {
+					counter.IncKey("asset|error|" + job.path)
+				}
+			}
+		}()
+	}
+}
+
+// Stops the shared asset workers and waits for them to return. Queued paths are discarded.
+// The pool is never started again, so call it only when the benchmark is over.
+func StopAssetPool() {
+	assetPoolOnce.Do(func() {})
+	if assetStop == nil {
+		return
+	}
+	select {
+	case <-assetStop:
+	default:
+		close(assetStop)
+	}
+	assetWorkers.Wait()
+}
+
+// Loads paths in the given order with the shared asset workers.
+// It does not wait for the completion like a browser does not block rendering.
+// Paths are dropped if the workers are too busy.
 func goLoadStaticFiles(ctx context.Context, checker *Checker, paths ...string) {
+	assetPoolOnce.Do(startAssetPool)
+	if assetStop == nil {
+		return
+	}
+
 	for _, path := range paths {
-		go loadStaticFile(ctx, checker, path)
+		select {
+		case assetJobs <- assetJob{ctx, checker, path}:
+		case <-ctx.Done():
+			return
+		case <-assetStop:
+			return
+		default:
+			counter.IncKey("asset|dropped|" + path)
+		}
 	}
 }
 
@@ -274,6 +435,7 @@ func LoadCreateUser(ctx context.Context, state *State) error {
 			"password":   user.Password,
 		},
 		Description: "新規ユーザが作成できること",
+		StrictJSON:  true,
 		CheckFunc:   checkJsonUserCreateResponse(user),
 	})
 	if err != nil {
@@ -317,6 +479,8 @@ func LoadTopPage(ctx context.Context, state *State) error {
 		Path:               "/",
 		ExpectedStatusCode: 200,
 		Description:        "ページが表示されること",
+		MaxRetries:         parameter.LoadMaxRetries,
+		RetryBackoff:       parameter.LoadRetryBackoff,
 	})
 	if err != nil {
 		return err
@@ -339,6 +503,8 @@ func LoadAdminTopPage(ctx context.Context, state *State) error {
 		Path:               "/admin/",
 		ExpectedStatusCode: 200,
 		Description:        "ページが表示されること",
+		MaxRetries:         parameter.LoadMaxRetries,
+		RetryBackoff:       parameter.LoadRetryBackoff,
 	})
 	if err != nil {
 		return err
@@ -365,6 +531,8 @@ func LoadMyPage(ctx context.Context, state *State) error {
 		Path:               fmt.Sprintf("/api/users/%d", user.ID),
 		ExpectedStatusCode: 200,
 		Description:        "ユーザー情報が取得できること",
+		MaxRetries:         parameter.LoadMaxRetries,
+		RetryBackoff:       parameter.LoadRetryBackoff,
 	})
 	if err != nil {
 		return err
@@ -446,6 +614,151 @@ func LoadReserveSheet(ctx context.Context, state *State) error {
 	return nil
 }
 
+// 予約してしばらく経ってからキャンセルするユーザがいる
+// 古い予約から順に何件かキャンセルして、予約が増え続けないようにする
+// 初期データの予約はCheckMyPageやCheckReportが参照するのでキャンセルしない
+func LoadReservationChurn(ctx context.Context, state *State) error {
+	// Closed or private events reject cancelation, and
+	// keep sold-out events sold out for LoadGetEvent and CheckCancelReserveSheet
+	eventMap := map[uint]*Event{}
+	for _, event := range FilterPublicEvents(state.GetEvents()) {
+		if !event.IsSoldOut() {
+			eventMap[event.ID] = event
+		}
+	}
+
+	// Pick candidates from a deep copy so that sorting does not race with reserve/cancel scenarios.
+	// Initial reservations are left as they are for CheckMyPage and CheckReport.
+	var candidates []*Reservation
+	for _, reservation := range state.GetCopiedReservations() {
+		if reservation.ReservedAt != 0 {
+			continue
+		}
+		if reservation.ReserveCompletedAt.IsZero() || !reservation.CancelRequestedAt.IsZero() {
+			continue
+		}
+		if _, ok := eventMap[reservation.EventID]; !ok {
+			continue
+		}
+		candidates = append(candidates, reservation)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ReserveCompletedAt.Before(candidates[j].ReserveCompletedAt)
+	})
+
+	canceled := 0
+	for _, candidate := range candidates {
+		if canceled >= parameter.LoadChurnMaxCancels {
+			break
+		}
+
+		err := func() error {
+			user, checker, userPush := state.PopUserByID(candidate.UserID)
+			if user == nil {
+				// The owner is used by somebody else
+				return nil
+			}
+			defer userPush()
+
+			reservation := state.FindCancelableReservationByID(candidate.ID)
+			if reservation == nil {
+				// Canceled while we were looking for the owner
+				return nil
+			}
+
+			err := loginAppUser(ctx, checker, user)
+			if err != nil {
+				return err
+			}
+
+			eventSheet := &EventSheet{reservation.EventID, reservation.SheetRank, reservation.SheetNum, reservation.Price}
+			already_locked, err := cancelSheet(ctx, state, checker, user, eventSheet, reservation)
+			if err != nil {
+				return err
+			}
+			if !already_locked {
+				// The sheet is free again, let reserve scenarios use it
+				state.PushEventSheet(eventSheet)
+				canceled++
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkNoDuplicateSheet(reservations []*Reservation) error {
+	seen := map[string]*Reservation{}
+	for _, r := range reservations {
+		key := fmt.Sprintf("%d-%s-%d", r.EventID, r.SheetRank, r.SheetNum)
+		if other, ok := seen[key]; ok {
+			log.Printf("warn: sheet %s is reserved twice (reservationID:%d and %d)\n", key, other.ID, r.ID)
+			return fatalErrorf("同じ席(%s-%d)が重複して予約されています(event_id:%d)", r.SheetRank, r.SheetNum, r.EventID)
+		}
+		seen[key] = r
+	}
+	return nil
+}
+
+// 一人のユーザーが予約を連打して、まとめてキャンセルする
+func LoadSingleUserReserveBurst(ctx context.Context, state *State) error {
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	var reservations []*Reservation
+	var eventSheets []*EventSheet
+	var eventSheetPushes []func()
+	defer func() {
+		for _, push := range eventSheetPushes {
+			push()
+		}
+	}()
+
+	for i := 0; i < parameter.ReserveBurstCount; i++ {
+		eventSheet, eventSheetPush, err := popOrCreateEventSheet(ctx, state)
+		if err != nil {
+			return err
+		}
+		if eventSheet == nil {
+			break
+		}
+
+		reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+		if err != nil {
+			return err
+		}
+		eventSheetPushes = append(eventSheetPushes, eventSheetPush) // NOTE: push only after reserve succeeds
+		reservations = append(reservations, reservation)
+		eventSheets = append(eventSheets, eventSheet)
+	}
+
+	err = checkNoDuplicateSheet(reservations)
+	if err != nil {
+		return err
+	}
+
+	for i, reservation := range reservations {
+		_, err := cancelSheet(ctx, state, userChecker, user, eventSheets[i], reservation)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // 売り切れたイベントをひたすらF5してキャンセルが出るのを待つユーザがいる
 func LoadGetEvent(ctx context.Context, state *State) error {
 	// LoadGetEvent() can run concurrently, but CheckCancelReserveSheet() can not
@@ -474,7 +787,9 @@ func LoadGetEvent(ctx context.Context, state *State) error {
 		Path:               fmt.Sprintf("/api/events/%d", event.ID),
 		ExpectedStatusCode: 200,
 		Description:        "公開イベントを取得できること",
-		CheckFunc:          checkJsonEventResponse(event, nil),
+		CheckFunc:          checkJsonEventResponse(state, event, nil),
+		MaxRetries:         parameter.LoadMaxRetries,
+		RetryBackoff:       parameter.LoadRetryBackoff,
 	})
 	if err != nil {
 		return err
@@ -533,7 +848,7 @@ func CheckGetEvent(ctx context.Context, state *State) error {
 		Path:               fmt.Sprintf("/api/events/%d", beforeEvent.ID),
 		ExpectedStatusCode: 200,
 		Description:        "公開イベントを取得できること",
-		CheckFunc: checkJsonEventResponse(beforeEvent, func(event JsonEvent) error {
+		CheckFunc: checkJsonEventResponse(state, beforeEvent, func(event JsonEvent) error {
 			afterEvent := state.GetEventByID(beforeEvent.ID)
 
 			err := checkEventList(state, []*Event{beforeEvent}, []JsonEvent{event}, []*Event{afterEvent})
@@ -573,6 +888,61 @@ func CheckGetEvent(ctx context.Context, state *State) error {
 	return nil
 }
 
+// 未ログインユーザーが同じ公開イベントを2回取得して、内容が一貫していることを確認する
+// キャッシュ関連のヘッダが付いているかどうかも数えておく
+func CheckGetEventAnonymous(ctx context.Context, state *State) error {
+	event := CopyEvent(state.GetRandomPublicEvent())
+	if event == nil {
+		return nil
+	}
+
+	checker := NewChecker()
+
+	var events []JsonEvent
+	for i := 0; i < 2; i++ {
+		err := checker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               fmt.Sprintf("/api/events/%d", event.ID),
+			ExpectedStatusCode: 200,
+			Description:        "未ログインで公開イベントを取得できること",
+			CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+				if res.Header.Get("Cache-Control") != "" || res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != "" {
+					counter.IncKey("event-cache-header-found")
+				} else {
+					counter.IncKey("event-cache-header-not-found")
+				}
+
+				return checkJsonEventResponse(state, event, func(e JsonEvent) error {
+					for rank, sheets := range e.Sheets {
+						for _, sheet := range sheets.Details {
+							if sheet.Mine {
+								return fatalErrorf("未ログインのユーザーがキャンセルできるシート(%s-%d)が存在します(id:%d)", rank, sheet.Num, event.ID)
+							}
+						}
+					}
+					events = append(events, e)
+					return nil
+				})(res, body)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	first, second := events[0], events[1]
+	if first.ID != second.ID || first.Title != second.Title || first.Total != second.Total {
+		return fatalErrorf("イベント(id:%d)の取得結果が一貫していません", event.ID)
+	}
+	for rank, sheets := range first.Sheets {
+		if sheets.Price != second.Sheets[rank].Price || sheets.Total != second.Sheets[rank].Total {
+			return fatalErrorf("イベント(id:%d)の%s席の取得結果が一貫していません", event.ID, rank)
+		}
+	}
+
+	return nil
+}
+
 func LoadReport(ctx context.Context, state *State) error {
 	admin, checker, push := state.PopRandomAdministrator()
 	if admin == nil {
@@ -592,6 +962,8 @@ func LoadReport(ctx context.Context, state *State) error {
 		ExpectedStatusCode: 200,
 		Description:        "レポートを取得できること",
 		Timeout:            parameter.PostTestReportTimeout,
+		MaxRetries:         parameter.LoadMaxRetries,
+		RetryBackoff:       parameter.LoadRetryBackoff,
 	})
 	if err != nil {
 		return err
@@ -625,6 +997,8 @@ func LoadEventReport(ctx context.Context, state *State) error {
 		Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", event.ID),
 		ExpectedStatusCode: 200,
 		Description:        "レポートを取得できること",
+		MaxRetries:         parameter.LoadMaxRetries,
+		RetryBackoff:       parameter.LoadRetryBackoff,
 	})
 	if err != nil {
 		return err
@@ -649,14 +1023,12 @@ func CheckStaticFiles(ctx context.Context, state *State) error {
 			Path:               sf.Path,
 			ExpectedStatusCode: 200,
 			Description:        "静的ファイルが取得できること",
+			EnableGzip:         true,
 			CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
-				hasher := md5.New()
-				_, err := io.Copy(hasher, body)
-				if err != nil {
-					return fatalErrorf("レスポンスボディの取得に失敗 %v", err)
+				if parameter.SkipStaticHash {
+					return nil
 				}
-				hash := hex.EncodeToString(hasher.Sum(nil))
-				if hash != sf.Hash {
+				if md5Hex(body.Bytes()) != sf.Hash {
 					return fatalErrorf("静的ファイルの内容が正しくありません")
 				}
 				return nil
@@ -672,12 +1044,13 @@ func CheckStaticFiles(ctx context.Context, state *State) error {
 
 func checkJsonUserCreateResponse(user *AppUser) func(res *http.Response, body *bytes.Buffer) error {
 	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
 		jsonUser := JsonUser{}
-		err := dec.Decode(&jsonUser)
+		err := decodeResponseJSON(res, body, &jsonUser)
 		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
+			return err
+		}
+		if isStrictJSON(res) && jsonUser.ID == 0 {
+			return fatalErrorf("作成したユーザのIDが返されていません")
 		}
 		if jsonUser.Nickname != user.Nickname {
 			log.Printf("warn: expected nickname=%s but got nickname=%s\n", user.Nickname, jsonUser.Nickname)
@@ -691,12 +1064,10 @@ func checkJsonUserCreateResponse(user *AppUser) func(res *http.Response, body *b
 
 func checkJsonUserResponse(user *AppUser) func(res *http.Response, body *bytes.Buffer) error {
 	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
 		jsonUser := JsonUser{}
-		err := dec.Decode(&jsonUser)
+		err := decodeJSON(body, &jsonUser)
 		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
+			return err
 		}
 		if jsonUser.ID != user.ID {
 			log.Printf("warn: expected id=%d but got id=%d\n", user.ID, jsonUser.ID)
@@ -726,6 +1097,7 @@ func CheckCreateUser(ctx context.Context, state *State) error {
 			"password":   user.Password,
 		},
 		Description: "新規ユーザが作成できること",
+		StrictJSON:  true,
 		CheckFunc:   checkJsonUserCreateResponse(user),
 	})
 	if err != nil {
@@ -769,62 +1141,53 @@ func CheckCreateUser(ctx context.Context, state *State) error {
 	return nil
 }
 
-func CheckLogin(ctx context.Context, state *State) error {
-	user, checker, push := state.PopRandomUser()
+// 作成したばかりのユーザーがそのまま予約できることを確認する
+func CheckReserveAfterSignup(ctx context.Context, state *State) error {
+	user, checker, newUserPush := state.PopNewUser()
 	if user == nil {
 		return nil
 	}
-	defer push()
 	checker.ResetCookie()
-	user.Status.Online = false
 
-	err := loginAppUser(ctx, checker, user)
+	err := checker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/api/users",
+		ExpectedStatusCode: 201,
+		PostJSON: map[string]interface{}{
+			"nickname":   user.Nickname,
+			"login_name": user.LoginName,
+			"password":   user.Password,
+		},
+		Description: "新規ユーザが作成できること",
+		StrictJSON:  true,
+		CheckFunc:   checkJsonUserCreateResponse(user),
+	})
 	if err != nil {
 		return err
 	}
+	// NOTE: The user exists on the server from here, so push it even if following requests fail
+	defer newUserPush()
 
-	err = logoutAppUser(ctx, checker, user)
+	err = loginAppUser(ctx, checker, user)
 	if err != nil {
 		return err
 	}
 
-	err = checker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/api/actions/logout",
-		ExpectedStatusCode: 401,
-		Description:        "ログアウト済みの場合エラーになること",
-		CheckFunc:          checkJsonErrorResponse("login_required"),
-	})
+	eventSheet, eventSheetPush, err := popOrCreateEventSheet(ctx, state)
 	if err != nil {
 		return err
 	}
+	if eventSheet == nil {
+		return nil
+	}
 
-	err = checker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/api/actions/login",
-		ExpectedStatusCode: 401,
-		PostJSON: map[string]interface{}{
-			"login_name": RandomAlphabetString(32),
-			"password":   user.Password,
-		},
-		Description: "存在しないユーザでログインできないこと",
-		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
-	})
+	reservation, err := reserveSheet(ctx, state, checker, user, eventSheet)
 	if err != nil {
 		return err
 	}
+	defer eventSheetPush() // NOTE: push only after reserve succeeds
 
-	err = checker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/api/actions/login",
-		ExpectedStatusCode: 401,
-		PostJSON: map[string]interface{}{
-			"login_name": user.LoginName,
-			"password":   RandomAlphabetString(32),
-		},
-		Description: "パスワードが間違っている場合ログインできないこと",
-		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
-	})
+	_, err = cancelSheet(ctx, state, checker, user, eventSheet, reservation)
 	if err != nil {
 		return err
 	}
@@ -832,40 +1195,207 @@ func CheckLogin(ctx context.Context, state *State) error {
 	return nil
 }
 
-func CheckTopPage(ctx context.Context, state *State) error {
-	user, checker, push := state.PopRandomUser()
-	if user == nil {
+// 巨大なリクエストボディを送ってもサーバーが落ちずに 4xx を返すこと
+func CheckOversizedRequestBody(ctx context.Context, state *State) error {
+	size := parameter.OversizedRequestBodySize
+	if size <= 0 {
 		return nil
 	}
-	defer push()
 
-	switch rand.Intn(3) {
-	case 0:
-		err := loginAppUser(ctx, checker, user)
-		if err != nil {
-			return err
-		}
-	case 1:
-		err := logoutAppUser(ctx, checker, user)
-		if err != nil {
-			return err
-		}
-		// case 2: do nothing
-	}
+	checker := NewChecker()
 
-	// Assume that public events are not modified (closed or private)
-	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
-	eventsBeforeRequest := FilterEventsToAllowDelay(FilterPublicEvents(state.GetCopiedEvents()), timeBefore)
+	// Stream the body not to allocate it on the benchmarker
+	body := io.MultiReader(
+		strings.NewReader(`{"nickname":"`),
+		io.LimitReader(repeatReader('a'), int64(size)),
+		strings.NewReader(fmt.Sprintf(`","login_name":"%s","password":"%s"}`, RandomAlphabetString(32), RandomAlphabetString(32))),
+	)
 
 	err := checker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               "/",
-		ExpectedStatusCode: 200,
-		Description:        "ページが表示されること",
-		CheckFunc: checkHTML(func(res *http.Response, doc *goquery.Document) error {
-			h := htmldigest.NewHash(func() hash.Hash {
-				return crc32.NewIEEE()
-			})
+		Method:      "POST",
+		Path:        "/api/users",
+		ContentType: "application/json",
+		PostBody:    body,
+		Description: "巨大なリクエストボディを拒否できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			if res.StatusCode < 400 || 500 <= res.StatusCode {
+				return fmt.Errorf("期待していないステータスコード %d Expected 4xx", res.StatusCode)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 長大なランクのシートを予約しようとしても遅延なくエラーになり、予約が記録されないこと
+func CheckReserveOversizedRank(ctx context.Context, state *State) error {
+	length := parameter.OversizedRankLength
+	if length <= 0 {
+		return nil
+	}
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	// No load scenario reserves on a dedicated event, so every sheet must remain
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReserveOversizedRank")
+	if err != nil {
+		return err
+	}
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/api/events/%d/actions/reserve", event.ID),
+		ExpectedStatusCode: 400,
+		Description:        "長大なランクのシートを予約しようとするとエラーになること",
+		CheckFunc:          checkJsonErrorResponse("invalid_rank"),
+		PostJSON: map[string]interface{}{
+			"sheet_rank": RandomAlphabetString(length),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "長大なランクの予約が記録されていないこと",
+		CheckFunc: checkJsonEventResponse(state, event, func(jsonEvent JsonEvent) error {
+			if jsonEvent.Remains != jsonEvent.Total {
+				return fatalErrorf("拒否されたはずの長大なランクの予約がイベント(id:%d)に記録されています", event.ID)
+			}
+			return nil
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CheckLogin(ctx context.Context, state *State) error {
+	user, checker, push := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer push()
+	checker.ResetCookie()
+	user.Status.Online = false
+
+	err := loginAppUser(ctx, checker, user)
+	if err != nil {
+		return err
+	}
+
+	err = logoutAppUser(ctx, checker, user)
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/api/actions/logout",
+		ExpectedStatusCode: 401,
+		Description:        "ログアウト済みの場合エラーになること",
+		CheckFunc:          checkJsonErrorResponse("login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/api/actions/login",
+		ExpectedStatusCode: 401,
+		PostJSON: map[string]interface{}{
+			"login_name": RandomAlphabetString(32),
+			"password":   user.Password,
+		},
+		Description: "存在しないユーザでログインできないこと",
+		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/api/actions/login",
+		ExpectedStatusCode: 401,
+		PostJSON: map[string]interface{}{
+			"login_name": user.LoginName,
+			"password":   RandomAlphabetString(32),
+		},
+		Description: "パスワードが間違っている場合ログインできないこと",
+		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CheckTopPage(ctx context.Context, state *State) error {
+	user, checker, push := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer push()
+
+	switch rand.Intn(3) {
+	case 0:
+		err := loginAppUser(ctx, checker, user)
+		if err != nil {
+			return err
+		}
+	case 1:
+		err := logoutAppUser(ctx, checker, user)
+		if err != nil {
+			return err
+		}
+		// case 2: do nothing
+	}
+
+	// Assume that public events are not modified (closed or private)
+	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
+	eventsBeforeRequest := FilterEventsToAllowDelay(FilterPublicEvents(state.GetCopiedEvents()), timeBefore)
+
+	err := checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Description:        "ページが表示されること",
+		CheckFunc: checkHTML(func(res *http.Response, doc *goquery.Document) error {
+			h := htmldigest.NewHash(func() hash.Hash {
+				return crc32.NewIEEE()
+			})
 			crcSum, err := h.Sum(doc.Nodes[0])
 			if err != nil {
 				fmt.Fprint(os.Stderr, "HTML: ")
@@ -1097,9 +1627,9 @@ func CheckMyPage(ctx context.Context, state *State) error {
 		Path:               fmt.Sprintf("/api/users/%d", user.ID),
 		ExpectedStatusCode: 200,
 		Description:        "ページが表示されること",
-		CheckFunc: checkJsonFullUserResponse(user, func(fullUser *JsonFullUser) error {
+		CheckFunc: checkJsonFullUserResponse(state, user, timeBefore, func(fullUser *JsonFullUser) error {
 			// check total price range
-			if !(user.Status.NegativeTotalPrice <= fullUser.TotalPrice || fullUser.TotalPrice <= user.Status.PositiveTotalPrice) {
+			if !(user.Status.NegativeTotalPrice <= fullUser.TotalPrice && fullUser.TotalPrice <= user.Status.PositiveTotalPrice) {
 				log.Printf("warn: miss match user total price expected=%s got=%d userID=%d\n", user.Status.TotalPriceString(), fullUser.TotalPrice, fullUser.ID)
 				return fatalErrorf("予約総額が最新の状態ではありません userID=%d", fullUser.ID)
 			}
@@ -1329,6 +1859,60 @@ func CheckMyPage(ctx context.Context, state *State) error {
 	return nil
 }
 
+// 他のユーザーのマイページが見られないこと、未ログインではマイページが見られないことを確認する
+func CheckMyPageAuthorization(ctx context.Context, state *State) error {
+	user, checker, push := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer push()
+
+	otherUser, _, otherPush := state.PopRandomUser()
+	if otherUser == nil {
+		return nil
+	}
+	defer otherPush()
+
+	err := loginAppUser(ctx, checker, user)
+	if err != nil {
+		return err
+	}
+
+	if parameter.MyPageAllowsOtherUsers {
+		err = checker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               fmt.Sprintf("/api/users/%d", otherUser.ID),
+			ExpectedStatusCode: 200,
+			Description:        "他のユーザーの情報が取得できること",
+			CheckFunc:          checkJsonUserResponse(otherUser),
+		})
+	} else {
+		err = checker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               fmt.Sprintf("/api/users/%d", otherUser.ID),
+			ExpectedStatusCode: 403,
+			Description:        "他のユーザーの情報が取得できないこと",
+			CheckFunc:          checkJsonErrorResponse("forbidden"),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	err = NewChecker().Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/users/%d", user.ID),
+		ExpectedStatusCode: 401,
+		Description:        "ログインしていない場合ユーザー情報が取得できないこと",
+		CheckFunc:          checkJsonErrorResponse("login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // たまには売り切れイベントをキャンセルさせて、キャッシュしにくくさせる
 // キャンセルを待ってイベントページをF5しているユーザもいる想定なのでキャンセルしてあげる
 // (簡単のため)キャンセルしたら別のユーザですぐに予約する
@@ -1465,20 +2049,10 @@ func CheckReserveSheet(ctx context.Context, state *State) error {
 	// 	return err
 	// }
 
-	// TODO(sonots): Need to find a sheet which somebody else reserved.
-	// err := userChecker.Play(ctx, &CheckAction{
-	// 	Method:      "DELETE",
-	// 	Path:        fmt.Sprintf("/api/events/%d/sheets/%s/%d/reservation", eventID, reservation.SheetRank, reservation.SheetNum),
-	// 	ExpectedStatusCode: 403,
-	// 	Description: "購入していないチケットをキャンセルしようとするとエラーになること",
-	//	CheckFunc:          checkJsonErrorResponse("not_permitted"),
-	// })
-	// if err != nil {
-	// 	return err
-	// }
+	// NOTE: Canceling a sheet which somebody else reserved is checked at CheckCancelOthersReservation
 
-	// TODO(sonots): Randomize, but find ID which does not exist.
-	unknownEventID := 0
+	// Far larger than any event created concurrently
+	unknownEventID := state.GetNonexistentEventID()
 	err = userChecker.Play(ctx, &CheckAction{
 		Method:             "POST",
 		Path:               fmt.Sprintf("/api/events/%d/actions/reserve", unknownEventID),
@@ -1573,30 +2147,13 @@ func CheckReserveSheet(ctx context.Context, state *State) error {
 	return nil
 }
 
-func checkJsonAdministratorResponse(admin *Administrator) func(res *http.Response, body *bytes.Buffer) error {
-	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
-		jsonAdmin := JsonAdministrator{}
-		err := dec.Decode(&jsonAdmin)
-		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
-		}
-		if jsonAdmin.ID != admin.ID || jsonAdmin.Nickname != admin.Nickname {
-			return fatalErrorf("正しい管理者情報を取得できません")
-		}
-		return nil
-	}
-}
-
-func CheckAdminLogin(ctx context.Context, state *State) error {
+// 一人のユーザーが同じランクを連打しても別々の席が割り当てられ、売り切れたら sold_out になることを確認する
+func CheckSingleUserReserveBurst(ctx context.Context, state *State) error {
 	admin, adminChecker, adminPush := state.PopRandomAdministrator()
 	if admin == nil {
 		return nil
 	}
 	defer adminPush()
-	adminChecker.ResetCookie()
-	admin.Status.Online = false
 
 	user, userChecker, userPush := state.PopRandomUser()
 	if user == nil {
@@ -1604,173 +2161,95 @@ func CheckAdminLogin(ctx context.Context, state *State) error {
 	}
 	defer userPush()
 
-	err := userChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/admin/api/actions/login",
-		ExpectedStatusCode: 401,
-		PostJSON: map[string]interface{}{
-			"login_name": user.LoginName,
-			"password":   user.Password,
-		},
-		Description: "一般ユーザで管理者ログインできないこと",
-		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
-	})
+	err := loginAdministrator(ctx, adminChecker, admin)
 	if err != nil {
 		return err
 	}
 
-	err = loginAdministrator(ctx, adminChecker, admin)
+	err = loginAppUser(ctx, userChecker, user)
 	if err != nil {
 		return err
 	}
 
-	err = logoutAdministrator(ctx, adminChecker, admin)
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckSingleUserReserveBurst")
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/admin/api/actions/logout",
-		ExpectedStatusCode: 401,
-		Description:        "ログアウト済みの場合エラーになること",
-		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
-	})
+	// S is the smallest rank, so the burst reaches sold_out in the fewest requests
+	rank := "S"
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	reservations, err := drainSheetRank(ctx, state, userChecker, user, event, rank)
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/admin/api/actions/login",
-		ExpectedStatusCode: 401,
-		PostJSON: map[string]interface{}{
-			"login_name": RandomAlphabetString(32),
-			"password":   admin.Password,
-		},
-		Description: "存在しないユーザで管理者ログインできないこと",
-		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
-	})
+	err = checkNoDuplicateSheet(reservations)
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
+	err = userChecker.Play(ctx, &CheckAction{
 		Method:             "POST",
-		Path:               "/admin/api/actions/login",
-		ExpectedStatusCode: 401,
+		Path:               fmt.Sprintf("/api/events/%d/actions/reserve", event.ID),
+		ExpectedStatusCode: 409,
+		Description:        "売り切れの場合エラーになること",
 		PostJSON: map[string]interface{}{
-			"login_name": admin.LoginName,
-			"password":   RandomAlphabetString(32),
+			"sheet_rank": rank,
 		},
-		Description: "パスワードが間違っている場合管理者ログインできないこと",
-		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
+		CheckFunc: checkJsonErrorResponse("sold_out"),
 	})
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func checkJsonFullEventCreateResponse(event *Event) func(res *http.Response, body *bytes.Buffer) error {
-	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
-		jsonEvent := JsonFullEvent{}
-		err := dec.Decode(&jsonEvent)
+	for _, reservation := range reservations {
+		eventSheet := &EventSheet{event.ID, rank, reservation.SheetNum, price}
+		_, err := cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
 		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
-		}
-		if jsonEvent.Title != event.Title || jsonEvent.Price != event.Price || jsonEvent.Public != event.PublicFg || jsonEvent.Closed != event.ClosedFg {
-			return fatalErrorf("正しいイベントを取得できません")
+			return err
 		}
-		// Set created time and auto incremented ID from response
-		event.ID = jsonEvent.ID
-		event.CreatedAt = time.Now()
-		return nil
 	}
-}
 
-func checkJsonFullEventResponse(event *Event) func(res *http.Response, body *bytes.Buffer) error {
-	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
-		jsonEvent := JsonFullEvent{}
-		err := dec.Decode(&jsonEvent)
-		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
-		}
-		if jsonEvent.ID != event.ID || jsonEvent.Title != event.Title || jsonEvent.Price != event.Price || jsonEvent.Public != event.PublicFg {
-			return fatalErrorf("正しいイベントを取得できません")
-		}
-		return nil
-	}
+	return nil
 }
 
-func checkJsonEventResponse(event *Event, cb func(JsonEvent) error) func(res *http.Response, body *bytes.Buffer) error {
-	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-
-		dec := json.NewDecoder(body)
-		jsonEvent := JsonEvent{}
-		err := dec.Decode(&jsonEvent)
-		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
-		}
-
-		// basic checks
-		if jsonEvent.ID != event.ID || jsonEvent.Title != event.Title || len(jsonEvent.Sheets) != len(DataSet.SheetKinds) {
-			return fatalErrorf("正しいイベント(id:%d)を取得できません", event.ID)
-		}
-		if jsonEvent.Sheets == nil {
-			return fatalErrorf("イベント(id:%d)のシート定義が取得できません", event.ID)
-		}
-		for rank, sheets := range jsonEvent.Sheets {
-			sheetKind := DataSet.SheetKindMap[rank]
-			if sheets.Details == nil || int(sheetKind.Total) != len(sheets.Details) {
-				return fatalErrorf("イベント(id:%d)のシートの詳細情報が取得できません", event.ID)
+func getEventSheetDetail(ctx context.Context, checker *Checker, eventID uint, rank string, num uint) (JsonSheet, *JsonSheetDetail, error) {
+	var sheet JsonSheet
+	var detail *JsonSheetDetail
+	err := checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", eventID),
+		ExpectedStatusCode: 200,
+		Description:        "公開イベントを取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			jsonEvent := JsonEvent{}
+			err := decodeJSON(body, &jsonEvent)
+			if err != nil {
+				return err
 			}
-
-			reservedCount := 0
-			for i, sheet := range sheets.Details {
-				if int(sheet.Num) != i+1 {
-					return fatalErrorf("イベント(id:%d)のシートの順番が違います", event.ID)
-				}
-				if sheet.Reserved {
-					reservedCount++
+			var ok bool
+			sheet, ok = jsonEvent.Sheets[rank]
+			if !ok {
+				return fatalErrorf("イベント(id:%d)の%sランクのシートがありません", eventID, rank)
+			}
+			for i := range sheet.Details {
+				if sheet.Details[i].Num == num {
+					detail = &sheet.Details[i]
 				}
 			}
-			if reservedCount != int(sheets.Total-sheets.Remains) {
-				return fatalErrorf("イベント(id:%d)のシートの予約状況が矛盾しています", event.ID)
+			if detail == nil {
+				return fatalErrorf("イベント(id:%d)のシート(%s-%d)がありません", eventID, rank, num)
 			}
-		}
-
-		if cb != nil {
-			return cb(jsonEvent)
-		}
-		return nil
-	}
-}
-
-func eventPostJSON(event *Event) map[string]interface{} {
-	return map[string]interface{}{
-		"title":  event.Title,
-		"public": event.PublicFg,
-		"price":  event.Price,
-	}
-}
-
-func eventEditJSON(event *Event) map[string]bool {
-	return map[string]bool{
-		"public": event.PublicFg,
-		"closed": event.ClosedFg,
-	}
+			return nil
+		},
+	})
+	return sheet, detail, err
 }
 
-func CheckCreateEvent(ctx context.Context, state *State) error {
-	checker := NewChecker()
-
+// キャンセルした席がちょうど1つ空席としてイベント詳細に反映されることを確認する
+func CheckCancelFreesSheet(ctx context.Context, state *State) error {
 	admin, adminChecker, adminPush := state.PopRandomAdministrator()
 	if admin == nil {
 		return nil
@@ -1793,459 +2272,3195 @@ func CheckCreateEvent(ctx context.Context, state *State) error {
 		return err
 	}
 
-	event, newEventPush := state.CreateNewEvent()
-
-	err = userChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/admin/api/events",
-		ExpectedStatusCode: 401,
-		Description:        "一般ユーザがイベントを作成できないこと",
-		PostJSON:           eventPostJSON(event),
-		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
-	})
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckCancelFreesSheet")
 	if err != nil {
 		return err
 	}
 
-	// Create as a private event
-	event.PublicFg = false
-
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               "/admin/api/events",
-		ExpectedStatusCode: 200,
-		Description:        "管理者がイベントを作成できること",
-		PostJSON:           eventPostJSON(event),
-		CheckFunc:          checkJsonFullEventCreateResponse(event),
-	})
+	rank := GetRandomSheetRank()
+	eventSheet := &EventSheet{event.ID, rank, NonReservedNum, event.Price + DataSet.SheetKindMap[rank].Price}
+	reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
 	if err != nil {
 		return err
 	}
-	newEventPush("CheckCreateEvent")
+	num := reservation.SheetNum
 
-	err = checker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/api/events/%d", event.ID),
-		ExpectedStatusCode: 404,
-		Description:        "非公開イベントを取得できないこと",
-		CheckFunc:          checkJsonErrorResponse("not_found"),
-	})
+	sheetBefore, detail, err := getEventSheetDetail(ctx, userChecker, event.ID, rank, num)
 	if err != nil {
 		return err
 	}
+	if !detail.Reserved || !detail.Mine {
+		return fatalErrorf("予約した席(%s-%d)が予約済みになっていません (event_id:%d)", rank, num, event.ID)
+	}
 
-	err = userChecker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
-		ExpectedStatusCode: 401,
-		Description:        "一般ユーザが管理者APIでイベントを取得できないこと",
-		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
-	})
+	_, err = cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
-		ExpectedStatusCode: 200,
-		Description:        "管理者が非公開イベントを取得できること",
-		CheckFunc:          checkJsonFullEventResponse(event),
-	})
+	sheetAfter, detail, err := getEventSheetDetail(ctx, userChecker, event.ID, rank, num)
 	if err != nil {
 		return err
 	}
+	if sheetAfter.Remains != sheetBefore.Remains+1 {
+		log.Printf("warn: remains of %s-rank %d -> %d by a cancel (eventID:%d)\n", rank, sheetBefore.Remains, sheetAfter.Remains, event.ID)
+		return fatalErrorf("キャンセル後の残席数が正しくありません (event_id:%d)", event.ID)
+	}
+	if detail.Reserved || detail.Mine {
+		return fatalErrorf("キャンセルした席(%s-%d)が空席になっていません (event_id:%d)", rank, num, event.ID)
+	}
 
-	err = userChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
-		ExpectedStatusCode: 401,
-		Description:        "一般ユーザがイベントを編集できないこと",
-		PostJSON:           eventPostJSON(event),
-		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
-	})
-	if err != nil {
-		return err
+	return nil
+}
+
+// 予約のパスに末尾のスラッシュがあっても一貫した挙動になることを確認する
+func CheckReserveTrailingSlash(ctx context.Context, state *State) error {
+	accept := parameter.ReserveTrailingSlash == "accept"
+	if !accept && parameter.ReserveTrailingSlash != "reject" {
+		return nil
 	}
 
-	// Publish an event
-	event.PublicFg = true
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
-		ExpectedStatusCode: 200,
-		Description:        "管理者がイベントを編集できること",
-		PostJSON:           eventEditJSON(event),
-		CheckFunc:          checkJsonFullEventResponse(event),
-	})
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
 	if err != nil {
 		return err
 	}
 
-	err = checker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/api/events/%d", event.ID),
-		ExpectedStatusCode: 200,
-		Description:        "公開イベントを取得できること",
-		CheckFunc:          checkJsonEventResponse(event, nil),
-	})
+	err = loginAppUser(ctx, userChecker, user)
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
-		ExpectedStatusCode: 200,
-		Description:        "管理者が公開イベントを取得できること",
-		CheckFunc:          checkJsonFullEventResponse(event),
-	})
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReserveTrailingSlash")
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID+1),
-		ExpectedStatusCode: 404,
-		Description:        "イベントが存在しない場合取得に失敗すること",
-		CheckFunc:          checkJsonErrorResponse("not_found"),
-	})
-	if err != nil {
+	rank := GetRandomSheetRank()
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+	path := fmt.Sprintf("/api/events/%d/actions/reserve/", event.ID)
+
+	if accept {
+		eventSheet := &EventSheet{event.ID, rank, NonReservedNum, price}
+		reservation, err := reserveSheetAt(ctx, state, userChecker, user, eventSheet, path)
+		if err != nil {
+			return err
+		}
+		_, err = cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               fmt.Sprintf("/api/events/%d", event.ID+1),
+	// Not recorded in state because it must be rejected
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               path,
 		ExpectedStatusCode: 404,
-		Description:        "イベントが存在しない場合取得に失敗すること",
-		CheckFunc:          checkJsonErrorResponse("not_found"),
+		Description:        "末尾にスラッシュのあるパスでは予約できないこと",
+		PostJSON: map[string]interface{}{
+			"sheet_rank": rank,
+		},
 	})
 	if err != nil {
 		return err
 	}
 
-	err = adminChecker.Play(ctx, &CheckAction{
-		Method:             "POST",
-		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID+1),
-		ExpectedStatusCode: 404,
-		Description:        "イベントが存在しない場合編集に失敗すること",
-		PostJSON:           eventPostJSON(event),
-		CheckFunc:          checkJsonErrorResponse("not_found"),
-	})
+	records, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
 	if err != nil {
 		return err
 	}
+	if len(records) != 0 {
+		log.Printf("warn: %d reservations are made by rejected requests (eventID:%d)\n", len(records), event.ID)
+		return fatalErrorf("エラーになった予約がレポートに含まれています (event_id:%d)", event.ID)
+	}
 
 	return nil
 }
 
-func checkReportHeader(reader *csv.Reader) error {
-	// reservation_id,event_id,rank,num,price,user_id,sold_at,canceled_at
-	row, err := reader.Read()
-	if err == io.EOF ||
-		len(row) != 8 ||
-		row[0] != "reservation_id" ||
-		row[1] != "event_id" ||
-		row[2] != "rank" ||
-		row[3] != "num" ||
-		row[4] != "price" ||
-		row[5] != "user_id" ||
-		row[6] != "sold_at" ||
-		row[7] != "canceled_at" {
-		return fatalErrorf("正しいCSVヘッダを取得できません")
+// 小文字や空白付きのランク名での予約が invalid_rank になる(または正規化される)ことを確認する
+func CheckReserveSheetRankCase(ctx context.Context, state *State) error {
+	normalize := parameter.SheetRankCaseHandling == "normalize"
+	if !normalize && parameter.SheetRankCaseHandling != "reject" {
+		return nil
 	}
-	return nil
-}
 
-func getReportRecords(s *State, reader *csv.Reader) (map[uint]*ReportRecord, error) {
-	// reservation_id,event_id,rank,num,price,user_id,sold_at,canceled_at
-	// 1,1,S,36,8000,1002,2018-08-17T04:55:30Z,2018-08-17T04:58:31Z
-	// 2,1,S,36,8000,1002,2018-08-17T04:55:32Z,
-	// 3,1,B,149,4000,1002,2018-08-17T04:55:33Z,
-	// 4,1,C,317,3000,1002,2018-08-17T04:55:34Z,
-	// 5,1,B,27,4000,1002,2018-08-17T04:55:36Z,
-	// 6,3,A,15,6000,1002,2018-08-17T04:55:38Z,
-	// 7,3,S,10,8000,1002,2018-08-17T04:55:41Z,2018-08-17T04:58:29Z
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
 
-	records := map[uint]*ReportRecord{}
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
 
-	line := 0
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		line++
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReserveSheetRankCase")
+	if err != nil {
+		return err
+	}
+
+	rank := GetRandomSheetRank()
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	for _, variant := range []string{strings.ToLower(rank), " " + rank + " "} {
+		if normalize {
+			// checkJsonReservationResponse checks that the reserved rank is the normalized one
+			eventSheet := &EventSheet{event.ID, rank, NonReservedNum, price}
+			reserved := &JsonReservation{SheetRank: rank}
+			reservation := &Reservation{EventID: event.ID, UserID: user.ID, SheetRank: rank, Price: price}
+			logID := state.BeginReservation(user, reservation)
+
+			err = userChecker.Play(ctx, &CheckAction{
+				Method:             "POST",
+				Path:               fmt.Sprintf("/api/events/%d/actions/reserve", event.ID),
+				ExpectedStatusCode: 202,
+				Description:        "ランク名が正規化されて予約できること",
+				PostJSON: map[string]interface{}{
+					"sheet_rank": variant,
+				},
+				CheckFunc: checkJsonReservationResponse(reserved, price, nil),
+			})
+			if err != nil {
+				user.Status.PositiveTotalPrice += price
+				return err
+			}
+			reservation.ID = reserved.ReservationID
+			reservation.SheetNum = reserved.SheetNum
+			err = state.CommitReservation(logID, user, reservation)
+			if err != nil {
+				return err
+			}
+			eventSheet.Num = reserved.SheetNum
+
+			_, err = cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Not recorded in state because it must be rejected
+		err = userChecker.Play(ctx, &CheckAction{
+			Method:             "POST",
+			Path:               fmt.Sprintf("/api/events/%d/actions/reserve", event.ID),
+			ExpectedStatusCode: 400,
+			Description:        "ランク名の大文字小文字や空白が異なる場合エラーになること",
+			PostJSON: map[string]interface{}{
+				"sheet_rank": variant,
+			},
+			CheckFunc: checkJsonErrorResponse("invalid_rank"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if normalize {
+		return nil
+	}
+
+	// No phantom reservation should be made by the rejected requests
+	records, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
+	if err != nil {
+		return err
+	}
+	if len(records) != 0 {
+		log.Printf("warn: %d reservations are made by rejected requests (eventID:%d)\n", len(records), event.ID)
+		return fatalErrorf("エラーになった予約がレポートに含まれています (event_id:%d)", event.ID)
+	}
+
+	return nil
+}
+
+// 不正な public の値でのイベント編集が 400 で拒否され、イベントの状態が変わらないことを確認する
+func CheckEditEventInvalidPublic(ctx context.Context, state *State) error {
+	if !parameter.EditRejectsInvalidPublic {
+		return nil
+	}
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	event, newEventPush := state.CreateNewEvent()
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを作成できること",
+		PostJSON:           eventPostJSON(event),
+		StrictJSON:         true,
+		CheckFunc:          checkJsonFullEventCreateResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+	newEventPush("CheckEditEventInvalidPublic")
+
+	for _, rawJSON := range []string{`{"public":"yes"}`, `{}`} {
+		err = adminChecker.Play(ctx, &CheckAction{
+			Method:             "POST",
+			Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+			ContentType:        "application/json",
+			PostBody:           strings.NewReader(rawJSON),
+			ExpectedStatusCode: 400,
+			Description:        "不正な値でイベントを編集できないこと",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// NOTE: event.PublicFg is not changed by the rejected edits
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "不正な編集でイベントが変更されていないこと",
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 席が1つだけ空く瞬間に、キャンセルと別ユーザーの予約を同時に投げる
+// 予約は sold_out になるかキャンセルされた席が割り当てられるかのどちらかで、同じ席に2人の所有者がいてはいけない
+func CheckReserveCancelRace(ctx context.Context, state *State) error {
+	if parameter.ReserveCancelRaceIterations <= 0 {
+		return nil
+	}
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	type owner struct {
+		user    *AppUser
+		checker *Checker
+	}
+	var owners [2]owner
+	for i := range owners {
+		user, checker, push := state.PopRandomUser()
+		if user == nil {
+			return nil
+		}
+		defer push()
+		owners[i] = owner{user, checker}
+	}
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+	for _, o := range owners {
+		err := loginAppUser(ctx, o.checker, o.user)
+		if err != nil {
+			return err
+		}
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReserveCancelRace")
+	if err != nil {
+		return err
+	}
+
+	// Fill the smallest rank so that a cancel is the only way to get a sheet
+	rank := "S"
+	total := int(DataSet.SheetKindMap[rank].Total)
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	reservations, err := drainSheetRank(ctx, state, owners[0].checker, owners[0].user, event, rank)
+	if err != nil {
+		return err
+	}
+	holders := make([]int, total) // index of owners
+
+	for i := 0; i < parameter.ReserveCancelRaceIterations; i++ {
+		idx := rand.Intn(len(reservations))
+		victim := reservations[idx]
+		canceler := owners[holders[idx]]
+		reserver := owners[1-holders[idx]]
+
+		var cancelErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			eventSheet := &EventSheet{event.ID, rank, victim.SheetNum, price}
+			_, cancelErr = cancelSheet(ctx, state, canceler.checker, canceler.user, eventSheet, victim)
+		}()
+
+		reservation, soldOut, reserveErr := reserveSheetOrSoldOut(ctx, state, reserver.checker, reserver.user, &EventSheet{event.ID, rank, NonReservedNum, price})
+		wg.Wait()
+		if cancelErr != nil {
+			return cancelErr
+		}
+		if reserveErr != nil {
+			return reserveErr
+		}
+
+		if soldOut {
+			// The reserve reached before the cancel. Now the sheet must be free.
+			reservation, err = reserveSheet(ctx, state, reserver.checker, reserver.user, &EventSheet{event.ID, rank, NonReservedNum, price})
+			if err != nil {
+				return err
+			}
+		}
+
+		if reservation.SheetNum != victim.SheetNum {
+			log.Printf("warn: canceled %s-%d but reserved %s-%d (eventID:%d)\n", rank, victim.SheetNum, rank, reservation.SheetNum, event.ID)
+			return fatalErrorf("満席のはずのイベントで空いていない席が予約されました (event_id:%d)", event.ID)
+		}
+
+		r1, r2 := state.FindDoubleBookedSheet(event.ID)
+		if r1 != nil {
+			log.Printf("warn: sheet %s-%d is owned by reservationID:%d and %d\n", r1.SheetRank, r1.SheetNum, r1.ID, r2.ID)
+			return fatalErrorf("同じ席が重複して予約されています (event_id:%d)", event.ID)
+		}
+
+		reservations[idx] = reservation
+		holders[idx] = 1 - holders[idx]
+	}
+
+	return nil
+}
+
+// Reserves all sheets of the rank of the event.
+// The event should be created by createDedicatedEvent so that load scenarios never pick its sheets.
+func drainSheetRank(ctx context.Context, state *State, checker *Checker, user *AppUser, event *Event, rank string) ([]*Reservation, error) {
+	total := int(DataSet.SheetKindMap[rank].Total)
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	reservations := make([]*Reservation, 0, total)
+	for i := 0; i < total; i++ {
+		eventSheet := &EventSheet{event.ID, rank, NonReservedNum, price}
+		reservation, err := reserveSheet(ctx, state, checker, user, eventSheet)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	return reservations, nil
+}
+
+// 他のユーザーの予約はキャンセルできず、予約した本人は引き続きキャンセルできることを確認する
+func CheckCancelOthersReservation(ctx context.Context, state *State) error {
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	otherUser, otherUserChecker, otherUserPush := state.PopRandomUser()
+	if otherUser == nil {
+		return nil
+	}
+	defer otherUserPush()
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, otherUserChecker, otherUser)
+	if err != nil {
+		return err
+	}
+
+	err = loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	eventSheet, eventSheetPush, err := popOrCreateEventSheet(ctx, state)
+	if err != nil {
+		return err
+	}
+	if eventSheet == nil {
+		return nil
+	}
+
+	reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+	if err != nil {
+		return err
+	}
+	defer eventSheetPush() // NOTE: push only after reserve succeeds
+
+	path := fmt.Sprintf("/api/events/%d/sheets/%s/%d/reservation", reservation.EventID, reservation.SheetRank, reservation.SheetNum)
+
+	err = otherUserChecker.Play(ctx, &CheckAction{
+		Method:             "DELETE",
+		Path:               path,
+		ExpectedStatusCode: 403,
+		Description:        "購入していないチケットをキャンセルしようとするとエラーになること",
+		CheckFunc:          checkJsonErrorResponse("not_permitted"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Administrators are not users, so they are not logged in as a user
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "DELETE",
+		Path:               path,
+		ExpectedStatusCode: 401,
+		Description:        "管理者が一般ユーザのチケットをキャンセルしようとするとエラーになること",
+		CheckFunc:          checkJsonErrorResponse("login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// カンマやダブルクォートを含むタイトルのイベントでも、レポートのCSVが壊れないことを確認する
+func CheckReportSpecialCharacterTitle(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	title := `a,"b",` + RandomAlphabetString(16)
+	event, err := createDedicatedEventWithTitle(ctx, state, adminChecker, "CheckReportSpecialCharacterTitle", title)
+	if err != nil {
+		return err
+	}
+
+	rank := GetRandomSheetRank()
+	eventSheet := &EventSheet{event.ID, rank, NonReservedNum, event.Price + DataSet.SheetKindMap[rank].Price}
+	reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+	if err != nil {
+		return err
+	}
+
+	// getReportRecords parses the CSV by encoding/csv, so broken escapes make it fail
+	records, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
+	if err != nil {
+		return err
+	}
+	record, ok := records[reservation.ID]
+	if !ok {
+		return fatalErrorf("レポートに予約id:%dの行が存在しません", reservation.ID)
+	}
+	if record.EventID != event.ID || record.SheetRank != rank || record.SheetNum != reservation.SheetNum || record.UserID != user.ID {
+		log.Printf("warn: report record %+v does not match reservation:%d (eventID:%d)\n", record, reservation.ID, event.ID)
+		return fatalErrorf("レポート(予約id:%d)の内容が正しくありません", reservation.ID)
+	}
+
+	_, err = cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 再起動前のセッションが、設計通り(parameter.SessionSurvivesRestart)に扱われることを確認する
+// ベンチマーカーはアプリを再起動できないので、再起動したことを State.NotifyServerRestarted で教えてもらう
+func CheckSessionAcrossRestart(ctx context.Context, state *State) error {
+	session, generation, capture := state.restartSession.take()
+
+	if capture {
+		var captured *restartSession
+		defer func() { state.restartSession.store(captured) }()
+
+		user, userChecker, userPush := state.PopRandomUser()
+		if user == nil {
+			return nil
+		}
+		defer userPush()
+
+		err := loginAppUser(ctx, userChecker, user)
+		if err != nil {
+			return err
+		}
+
+		captured = &restartSession{user: user, generation: generation, cookies: userChecker.Cookies()}
+		return nil
+	}
+
+	if session == nil {
+		return nil
+	}
+	user := session.user
+
+	// Use a fresh checker not to break the session of the user in state
+	checker := NewChecker()
+	checker.SetCookies(session.cookies)
+
+	if parameter.SessionSurvivesRestart {
+		err := checker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               fmt.Sprintf("/api/users/%d", user.ID),
+			ExpectedStatusCode: 200,
+			Description:        "再起動前のセッションでマイページを取得できること",
+		})
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	err := checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/users/%d", user.ID),
+		ExpectedStatusCode: 401,
+		Description:        "再起動前のセッションが無効になっていること",
+		CheckFunc:          checkJsonErrorResponse("login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/api/actions/login",
+		ExpectedStatusCode: 200,
+		Description:        "再起動後に再ログインできること",
+		PostJSON: map[string]interface{}{
+			"login_name": user.LoginName,
+			"password":   user.Password,
+		},
+		CheckFunc: checkJsonUserResponse(user),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// イベント数の上限(parameter.EventCap)まで作成し、それを超える作成が失敗することを確認する
+// 上限がない場合は、続けて作成したイベントのIDが単調増加することを確認する
+func CheckEventCap(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	count := parameter.EventCapCheckCount
+	if parameter.EventCap > 0 {
+		count = parameter.EventCap - len(state.GetEvents())
+	}
+
+	var lastID uint
+	for i := 0; i < count; i++ {
+		event, newEventPush := state.CreateNewEvent()
+		// Create as a private event not to affect the top page
+		event.PublicFg = false
+
+		err := adminChecker.Play(ctx, &CheckAction{
+			Method:             "POST",
+			Path:               "/admin/api/events",
+			ExpectedStatusCode: 200,
+			Description:        "管理者がイベントを作成できること",
+			PostJSON:           eventPostJSON(event),
+			StrictJSON:         true,
+			CheckFunc:          checkJsonFullEventCreateResponse(event),
+		})
+		if err != nil {
+			return err
+		}
+		newEventPush("CheckEventCap")
+
+		if event.ID <= lastID {
+			log.Printf("warn: created event id=%d is not greater than the previous id=%d\n", event.ID, lastID)
+			return fatalErrorf("作成したイベントのIDが単調増加していません")
+		}
+		lastID = event.ID
+	}
+
+	if parameter.EventCap <= 0 {
+		return nil
+	}
+
+	// NOTE: The event must not be pushed into state because it should not be created
+	event, _ := state.CreateNewEvent()
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 400,
+		Description:        "イベント数の上限を超えて作成できないこと",
+		PostJSON:           eventPostJSON(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 同じユーザーが2つのクライアントから同時にログインしたとき、設計通り(parameter.MultipleSessionsAllowed)にセッションが扱われることを確認する
+func CheckConcurrentSameUserLogin(ctx context.Context, state *State) error {
+	user, _, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	checkers := []*Checker{NewChecker(), NewChecker()}
+	errs := make([]error, len(checkers))
+
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func(i int, checker *Checker) {
+			defer wg.Done()
+			errs[i] = checker.Play(ctx, &CheckAction{
+				Method:             "POST",
+				Path:               "/api/actions/login",
+				ExpectedStatusCode: 200,
+				Description:        "同じユーザーで同時にログインできること",
+				PostJSON: map[string]interface{}{
+					"login_name": user.LoginName,
+					"password":   user.Password,
+				},
+				CheckFunc: checkJsonUserResponse(user),
+			})
+		}(i, checker)
+	}
+	wg.Wait()
+
+	// The session of the user's own checker may be invalidated by the logins above
+	if !parameter.MultipleSessionsAllowed {
+		user.Status.Online = false
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	valid := 0
+	for _, checker := range checkers {
+		err := checker.Play(ctx, &CheckAction{
+			Method:      "GET",
+			Path:        fmt.Sprintf("/api/users/%d", user.ID),
+			Description: "同時にログインしたセッションでマイページを取得できること",
+			CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+				switch res.StatusCode {
+				case 200:
+					valid++
+					return checkJsonUserResponse(user)(res, body)
+				case 401:
+					return checkJsonErrorResponse("login_required")(res, body)
+				}
+				return fmt.Errorf("期待していないステータスコード %d", res.StatusCode)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if parameter.MultipleSessionsAllowed && valid != len(checkers) {
+		return fatalErrorf("同時にログインしたセッションの一部が無効になっています userID=%d", user.ID)
+	}
+	if !parameter.MultipleSessionsAllowed && valid != 1 {
+		log.Printf("warn: %d sessions of userID=%d are valid\n", valid, user.ID)
+		return fatalErrorf("同時にログインしたセッションのうち1つだけが有効になっていません userID=%d", user.ID)
+	}
+
+	return nil
+}
+
+// 全てのランクについて、予約されていないシートのキャンセルが not_reserved になることを確認する
+// 境界の番号も確認するため、誰も予約しない専用のイベントを使う
+func CheckCancelNotReservedEachRank(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckCancelNotReservedEachRank")
+	if err != nil {
+		return err
+	}
+
+	for _, sheetKind := range DataSet.SheetKinds {
+		for _, num := range []uint{1, GetRandomSheetNum(sheetKind.Rank), sheetKind.Total} {
+			err := userChecker.Play(ctx, &CheckAction{
+				Method:             "DELETE",
+				Path:               fmt.Sprintf("/api/events/%d/sheets/%s/%d/reservation", event.ID, sheetKind.Rank, num),
+				ExpectedStatusCode: 400,
+				Description:        "予約されていないシートをキャンセルしようとするとエラーになること",
+				CheckFunc:          checkJsonErrorResponse("not_reserved"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 複数のユーザーが同じイベントの同じランクを同時に予約して、同じ席が割り当てられないことを確認する
+func CheckConcurrentReserve(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	type racer struct {
+		user    *AppUser
+		checker *Checker
+	}
+	var racers []racer
+	for i := 0; i < parameter.ConcurrentReserveUsers; i++ {
+		user, userChecker, userPush := state.PopRandomUser()
+		if user == nil {
+			break
+		}
+		defer userPush()
+
+		err := loginAppUser(ctx, userChecker, user)
+		if err != nil {
+			return err
+		}
+		racers = append(racers, racer{user, userChecker})
+	}
+	if len(racers) < 2 {
+		return nil
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckConcurrentReserve")
+	if err != nil {
+		return err
+	}
+	rank := GetRandomSheetRank()
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	var mtx sync.Mutex
+	var reservations []*Reservation
+	errs := make([]error, len(racers))
+
+	var wg sync.WaitGroup
+	for i, r := range racers {
+		wg.Add(1)
+		go func(i int, r racer) {
+			defer wg.Done()
+			for j := 0; j < parameter.ConcurrentReserveRounds; j++ {
+				// sold_out is legitimate once the rank is drained
+				reservation, soldOut, err := reserveSheetOrSoldOut(ctx, state, r.checker, r.user, &EventSheet{event.ID, rank, NonReservedNum, price})
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				if soldOut {
+					return
+				}
+				mtx.Lock()
+				reservations = append(reservations, reservation)
+				mtx.Unlock()
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return checkNoDuplicateSheet(reservations)
+}
+
+// 全ての席が予約されたランクを予約しようとすると sold_out になることを確認する
+func CheckReserveSoldOut(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReserveSoldOut")
+	if err != nil {
+		return err
+	}
+
+	// The smallest rank to reduce requests
+	rank := "S"
+	_, err = drainSheetRank(ctx, state, userChecker, user, event, rank)
+	if err != nil {
+		return err
+	}
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/api/events/%d/actions/reserve", event.ID),
+		ExpectedStatusCode: 409,
+		Description:        "売り切れの場合エラーになること",
+		PostJSON: map[string]interface{}{
+			"sheet_rank": rank,
+		},
+		CheckFunc: checkJsonErrorResponse("sold_out"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Same with reserveSheet, but sold_out is also accepted
+func reserveSheetOrSoldOut(ctx context.Context, state *State, checker *Checker, user *AppUser, eventSheet *EventSheet) (*Reservation, bool, error) {
+	eventID := eventSheet.EventID
+	rank := eventSheet.Rank
+
+	reserved := &JsonReservation{ReservationID: 0, SheetRank: rank, SheetNum: 0}
+	reservation := &Reservation{ID: 0, EventID: eventID, UserID: user.ID, SheetRank: rank, Price: eventSheet.Price, SheetNum: 0}
+	logID := state.BeginReservation(user, reservation)
+
+	soldOut := false
+	err := checker.Play(ctx, &CheckAction{
+		Method:      "POST",
+		Path:        fmt.Sprintf("/api/events/%d/actions/reserve", eventID),
+		Description: "席の予約ができるか、売り切れの場合エラーになること",
+		PostJSON: map[string]interface{}{
+			"sheet_rank": rank,
+		},
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			switch res.StatusCode {
+			case 202:
+				return checkJsonReservationResponse(reserved, eventSheet.Price, nil)(res, body)
+			case 409:
+				soldOut = true
+				return checkJsonErrorResponse("sold_out")(res, body)
+			}
+			return fatalErrorf("Response code should be 202 or 409, got %d", res.StatusCode)
+		},
+	})
+	if soldOut && err == nil {
+		// Definitely not reserved, so the upper bound of the total price gets back
+		user.Status.PositiveTotalPrice -= eventSheet.Price
+		return nil, true, nil
+	}
+	if err != nil {
+		user.Status.PositiveTotalPrice += eventSheet.Price
+		return nil, soldOut, err
+	}
+
+	reservation.ID = reserved.ReservationID
+	reservation.SheetNum = reserved.SheetNum
+	err = state.CommitReservation(logID, user, reservation)
+	if err != nil {
+		return nil, false, err
+	}
+	eventSheet.Num = reserved.SheetNum
+
+	log.Printf("debug: reserve userID:%d(total-price:%s) eventID:%d reservedID:%d(%s-%d) price:%d\n", user.ID, user.Status.TotalPriceString(), eventID, reserved.ReservationID, reserved.SheetRank, reserved.SheetNum, eventSheet.Price)
+	return reservation, false, nil
+}
+
+// Returns the value of an attribute of #app-wrapper
+func findAppWrapperAttr(doc *goquery.Document, key string) (string, error) {
+	selection := doc.Find("#app-wrapper")
+	if selection == nil || len(selection.Nodes) == 0 {
+		return "", fatalErrorf("app-wrapperが見つかりません")
+	}
+	val, ok := selection.Attr(key)
+	if !ok {
+		return "", fatalErrorf("app-wrapperに%sがありません", key)
+	}
+	return val, nil
+}
+
+func checkEventNotInTopPage(eventID uint) func(res *http.Response, body *bytes.Buffer) error {
+	return checkHTML(func(res *http.Response, doc *goquery.Document) error {
+		val, err := findAppWrapperAttr(doc, "data-events")
+		if err != nil {
+			return err
+		}
+		var events []JsonEvent
+		err = json.Unmarshal([]byte(val), &events)
+		if err != nil {
+			return fatalErrorf("トップページのイベント一覧のJsonデコードに失敗 %s %v", val, err)
+		}
+		for _, e := range events {
+			if e.ID == eventID {
+				return fatalErrorf("非公開のイベント(id:%d)がトップページに表示されています", eventID)
+			}
+		}
+		return nil
+	})
+}
+
+// ログインしていないユーザーのトップページの data-login-user が null であることを確認する
+func CheckTopPageAnonymous(ctx context.Context, state *State) error {
+	checker := NewChecker()
+
+	err := checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Description:        "ログインしていない場合のトップページが表示されること",
+		CheckFunc: checkHTML(func(res *http.Response, doc *goquery.Document) error {
+			val, err := findAppWrapperAttr(doc, "data-login-user")
+			if err != nil {
+				return err
+			}
+			if val != "null" {
+				return fatalErrorf("ログインユーザーが非null")
+			}
+			return nil
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// public を省略してイベントを作成すると非公開になることを確認する
+func CheckCreateEventDefaultPrivate(ctx context.Context, state *State) error {
+	checker := NewChecker()
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	event, newEventPush := state.CreateNewEvent()
+	event.PublicFg = false
+
+	postJSON := eventPostJSON(event)
+	delete(postJSON, "public")
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 200,
+		Description:        "publicを省略したイベントは非公開で作成されること",
+		PostJSON:           postJSON,
+		StrictJSON:         true,
+		CheckFunc:          checkJsonFullEventCreateResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+	newEventPush("CheckCreateEventDefaultPrivate")
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Description:        "非公開のイベントがトップページに表示されないこと",
+		CheckFunc:          checkEventNotInTopPage(event.ID),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 非公開のイベントがログインしていないユーザーのトップページにも API にも現れないことを確認する
+func CheckPrivateEventHidden(ctx context.Context, state *State) error {
+	checker := NewChecker()
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	event, newEventPush := state.CreateNewEvent()
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 200,
+		Description:        "管理者が非公開のイベントを作成できること",
+		PostJSON:           eventPostJSON(event),
+		CheckFunc:          checkJsonFullEventCreateResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+	newEventPush("CheckPrivateEventHidden")
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Description:        "非公開のイベントがトップページに表示されないこと",
+		CheckFunc:          checkEventNotInTopPage(event.ID),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID),
+		ExpectedStatusCode: 404,
+		Description:        "非公開のイベントを取得できないこと",
+		CheckFunc:          checkJsonErrorResponse("not_found"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 公開したイベントを非公開に戻すと取得できなくなり、トップページからも消えることを確認する
+func CheckUnpublishEvent(ctx context.Context, state *State) error {
+	checker := NewChecker()
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	// A dedicated event is published but its sheets are not used by load scenarios
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckUnpublishEvent")
+	if err != nil {
+		return err
+	}
+
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを非公開に戻せること",
+		PostJSON:           eventEditJSON(event),
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID),
+		ExpectedStatusCode: 404,
+		Description:        "非公開に戻したイベントを取得できないこと",
+		CheckFunc:          checkJsonErrorResponse("not_found"),
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(parameter.AllowableDelay):
+	case <-ctx.Done():
+		return nil
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Description:        "非公開に戻したイベントがトップページに表示されないこと",
+		CheckFunc:          checkEventNotInTopPage(event.ID),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ログアウト前のセッションのクッキーを再送しても、ログアウト後はログインしていない扱いになることを確認する
+func CheckSessionInvalidation(ctx context.Context, state *State) error {
+	if !parameter.LogoutInvalidatesSession {
+		return nil
+	}
+
+	user, checker, push := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer push()
+
+	err := loginAppUser(ctx, checker, user)
+	if err != nil {
+		return err
+	}
+	cookies := checker.Cookies()
+
+	err = logoutAppUser(ctx, checker, user)
+	if err != nil {
+		return err
+	}
+
+	// Replay from another client so that the user's checker keeps its own cookies
+	replayChecker := NewChecker()
+	replayChecker.SetCookies(cookies)
+
+	err = replayChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/users/%d", user.ID),
+		ExpectedStatusCode: 401,
+		Description:        "ログアウトしたセッションが無効になっていること",
+		CheckFunc:          checkJsonErrorResponse("login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// セッションが切れた管理者がレポートを取得しようとしたら、途中までのレポートではなく 401 が返ることを確認する
+func CheckReportExpiredAdminSession(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	cookies := adminChecker.Cookies()
+	adminChecker.ExpireCookies()
+	admin.Status.Online = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/admin/api/reports/sales",
+		ExpectedStatusCode: 401,
+		Description:        "セッションが切れた管理者がレポートを取得できないこと",
+		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Restore the session which is not logged out
+	adminChecker.SetCookies(cookies)
+	admin.Status.Online = true
+
+	return nil
+}
+
+func checkJsonAdministratorResponse(admin *Administrator) func(res *http.Response, body *bytes.Buffer) error {
+	return func(res *http.Response, body *bytes.Buffer) error {
+		jsonAdmin := JsonAdministrator{}
+		err := decodeJSON(body, &jsonAdmin)
+		if err != nil {
+			return err
+		}
+		if jsonAdmin.ID != admin.ID || jsonAdmin.Nickname != admin.Nickname {
+			return fatalErrorf("正しい管理者情報を取得できません")
+		}
+		return nil
+	}
+}
+
+func CheckAdminLogin(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+	adminChecker.ResetCookie()
+	admin.Status.Online = false
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := userChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/actions/login",
+		ExpectedStatusCode: 401,
+		PostJSON: map[string]interface{}{
+			"login_name": user.LoginName,
+			"password":   user.Password,
+		},
+		Description: "一般ユーザで管理者ログインできないこと",
+		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = logoutAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/actions/logout",
+		ExpectedStatusCode: 401,
+		Description:        "ログアウト済みの場合エラーになること",
+		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/actions/login",
+		ExpectedStatusCode: 401,
+		PostJSON: map[string]interface{}{
+			"login_name": RandomAlphabetString(32),
+			"password":   admin.Password,
+		},
+		Description: "存在しないユーザで管理者ログインできないこと",
+		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/actions/login",
+		ExpectedStatusCode: 401,
+		PostJSON: map[string]interface{}{
+			"login_name": admin.LoginName,
+			"password":   RandomAlphabetString(32),
+		},
+		Description: "パスワードが間違っている場合管理者ログインできないこと",
+		CheckFunc:   checkJsonErrorResponse("authentication_failed"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkJsonFullEventCreateResponse(event *Event) func(res *http.Response, body *bytes.Buffer) error {
+	return func(res *http.Response, body *bytes.Buffer) error {
+		jsonEvent := JsonFullEvent{}
+		err := decodeResponseJSON(res, body, &jsonEvent)
+		if err != nil {
+			return err
+		}
+		// NOTE: public and closed may be omitted if false like the reference implementation
+		if isStrictJSON(res) && (jsonEvent.ID == 0 || jsonEvent.Sheets == nil) {
+			return fatalErrorf("作成したイベントのIDまたはシート定義が返されていません")
+		}
+		if jsonEvent.Title != event.Title || jsonEvent.Price != event.Price || jsonEvent.Public != event.PublicFg || jsonEvent.Closed != event.ClosedFg {
+			return fatalErrorf("正しいイベントを取得できません")
+		}
+		// A just created event has all sheets remained
+		if jsonEvent.Sheets != nil {
+			if err := checkEventSheetTotals(jsonEvent.JsonEvent, true); err != nil {
+				return err
+			}
+		}
+		// Set created time and auto incremented ID from response
+		event.ID = jsonEvent.ID
+		event.CreatedAt = time.Now()
+		return nil
+	}
+}
+
+func checkJsonFullEventResponse(event *Event) func(res *http.Response, body *bytes.Buffer) error {
+	return func(res *http.Response, body *bytes.Buffer) error {
+		jsonEvent := JsonFullEvent{}
+		err := decodeJSON(body, &jsonEvent)
+		if err != nil {
+			return err
+		}
+		if jsonEvent.ID != event.ID || jsonEvent.Title != event.Title || jsonEvent.Price != event.Price || jsonEvent.Public != event.PublicFg {
+			return fatalErrorf("正しいイベントを取得できません")
+		}
+		return checkEventSheetTotals(jsonEvent.JsonEvent, false)
+	}
+}
+
+// event must reflect the state before the request to tolerate reservations in-flight
+func checkJsonEventResponse(state *State, event *Event, cb func(JsonEvent) error) func(res *http.Response, body *bytes.Buffer) error {
+	_, reserveCompletedBefore, _, cancelCompletedBefore := event.GetReservationTickets()
+
+	return func(res *http.Response, body *bytes.Buffer) error {
+		jsonEvent := JsonEvent{}
+		err := decodeJSON(body, &jsonEvent)
+		if err != nil {
+			return err
+		}
+
+		// basic checks
+		if jsonEvent.ID != event.ID || jsonEvent.Title != event.Title || len(jsonEvent.Sheets) != len(DataSet.SheetKinds) {
+			return fatalErrorf("正しいイベント(id:%d)を取得できません", event.ID)
+		}
+		if jsonEvent.Sheets == nil {
+			return fatalErrorf("イベント(id:%d)のシート定義が取得できません", event.ID)
+		}
+		for rank, sheets := range jsonEvent.Sheets {
+			sheetKind := DataSet.SheetKindMap[rank]
+			if sheets.Details == nil || int(sheetKind.Total) != len(sheets.Details) {
+				return fatalErrorf("イベント(id:%d)のシートの詳細情報が取得できません", event.ID)
+			}
+
+			reservedCount := 0
+			for i, sheet := range sheets.Details {
+				if int(sheet.Num) != i+1 {
+					return fatalErrorf("イベント(id:%d)のシートの順番が違います", event.ID)
+				}
+				if sheet.Reserved {
+					reservedCount++
+				}
+			}
+			if reservedCount != int(sheets.Total-sheets.Remains) {
+				return fatalErrorf("イベント(id:%d)のシートの予約状況が矛盾しています", event.ID)
+			}
+		}
+
+		// remains must be explained by the reservations in-flight
+		if int(jsonEvent.Total) != len(DataSet.Sheets) {
+			return fatalErrorf("イベント(id:%d)の総座席数が正しくありません", event.ID)
+		}
+		if afterEvent := state.GetEventByID(event.ID); afterEvent != nil {
+			reserveRequestedAfter, _, cancelRequestedAfter, _ := afterEvent.GetReservationTickets()
+			for _, sheetKind := range DataSet.SheetKinds {
+				rank := sheetKind.Rank
+				sheets, ok := jsonEvent.Sheets[rank]
+				if !ok || sheets.Total != sheetKind.Total {
+					return fatalErrorf("イベント(id:%d)の%s席の総座席数が正しくありません", event.ID, rank)
+				}
+
+				err := checkRemains(
+					event.ID,
+					sheetKind.Total,
+					cancelCompletedBefore.Get(rank),
+					reserveRequestedAfter.Get(rank),
+					sheets.Remains,
+					cancelRequestedAfter.Get(rank),
+					reserveCompletedBefore.Get(rank))
+				if err != nil {
+					return fatalErrorf("イベント(id:%d)の%s席の残座席数が正しくありません", event.ID, rank)
+				}
+			}
+		}
+
+		if cb != nil {
+			return cb(jsonEvent)
+		}
+		return nil
+	}
+}
+
+// Checks the number of sheets of an event matches with the canonical sheets.
+// If fresh is true, all sheets must be remained.
+func checkEventSheetTotals(e JsonEvent, fresh bool) error {
+	if int(e.Total) != len(DataSet.Sheets) {
+		return fatalErrorf("イベント(id:%d)の総座席数が正しくありません", e.ID)
+	}
+	if e.Sheets == nil {
+		return fatalErrorf("イベント(id:%d)のシート定義が取得できません", e.ID)
+	}
+
+	var total, remains uint
+	for _, sheetKind := range DataSet.SheetKinds {
+		sheets, ok := e.Sheets[sheetKind.Rank]
+		if !ok || sheets.Total != sheetKind.Total {
+			return fatalErrorf("イベント(id:%d)の%s席の総座席数が正しくありません", e.ID, sheetKind.Rank)
+		}
+		total += sheets.Total
+		remains += sheets.Remains
+	}
+	if total != e.Total {
+		return fatalErrorf("イベント(id:%d)の総座席数が席種ごとの座席数の合計と一致しません", e.ID)
+	}
+	if remains != e.Remains {
+		return fatalErrorf("イベント(id:%d)の総残座席数が席種ごとの残座席数の合計と一致しません", e.ID)
+	}
+	if fresh && e.Remains != e.Total {
+		return fatalErrorf("作成したイベント(id:%d)の残座席数が総座席数と一致しません", e.ID)
+	}
+	return nil
+}
+
+func eventPostJSON(event *Event) map[string]interface{} {
+	return map[string]interface{}{
+		"title":  event.Title,
+		"public": event.PublicFg,
+		"price":  event.Price,
+	}
+}
+
+func eventEditJSON(event *Event) map[string]bool {
+	return map[string]bool{
+		"public": event.PublicFg,
+		"closed": event.ClosedFg,
+	}
+}
+
+func CheckCreateEvent(ctx context.Context, state *State) error {
+	checker := NewChecker()
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, newEventPush := state.CreateNewEvent()
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 401,
+		Description:        "一般ユーザがイベントを作成できないこと",
+		PostJSON:           eventPostJSON(event),
+		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create as a private event
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを作成できること",
+		PostJSON:           eventPostJSON(event),
+		StrictJSON:         true,
+		CheckFunc:          checkJsonFullEventCreateResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+	newEventPush("CheckCreateEvent")
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID),
+		ExpectedStatusCode: 404,
+		Description:        "非公開イベントを取得できないこと",
+		CheckFunc:          checkJsonErrorResponse("not_found"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
+		ExpectedStatusCode: 401,
+		Description:        "一般ユーザが管理者APIでイベントを取得できないこと",
+		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者が非公開イベントを取得できること",
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+		ExpectedStatusCode: 401,
+		Description:        "一般ユーザがイベントを編集できないこと",
+		PostJSON:           eventPostJSON(event),
+		CheckFunc:          checkJsonErrorResponse("admin_login_required"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Publish an event
+	event.PublicFg = true
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを編集できること",
+		PostJSON:           eventEditJSON(event),
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "公開イベントを取得できること",
+		CheckFunc:          checkJsonEventResponse(state, event, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者が公開イベントを取得できること",
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID+1),
+		ExpectedStatusCode: 404,
+		Description:        "イベントが存在しない場合取得に失敗すること",
+		CheckFunc:          checkJsonErrorResponse("not_found"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID+1),
+		ExpectedStatusCode: 404,
+		Description:        "イベントが存在しない場合取得に失敗すること",
+		CheckFunc:          checkJsonErrorResponse("not_found"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID+1),
+		ExpectedStatusCode: 404,
+		Description:        "イベントが存在しない場合編集に失敗すること",
+		PostJSON:           eventPostJSON(event),
+		CheckFunc:          checkJsonErrorResponse("not_found"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 作成したイベントに正しい数の座席が割り当てられていることを確認する
+func CheckCreateEventSheets(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	// Create as a private event not to be reserved by others
+	event, newEventPush := state.CreateNewEvent()
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを作成できること",
+		PostJSON:           eventPostJSON(event),
+		StrictJSON:         true,
+		CheckFunc:          checkJsonFullEventCreateResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+	newEventPush("CheckCreateEventSheets")
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者が作成したイベントを取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			jsonEvent := JsonFullEvent{}
+			err := decodeJSON(body, &jsonEvent)
+			if err != nil {
+				return err
+			}
+			if jsonEvent.ID != event.ID {
+				return fatalErrorf("正しいイベントを取得できません")
+			}
+			return checkEventSheetTotals(jsonEvent.JsonEvent, true)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// クローズしたイベントの予約をキャンセルしようとしたときの挙動を確認する
+// 期待する挙動は parameter.CloseFreezesCancel で切り替える
+func CheckCloseFreezesCancel(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckCloseFreezesCancel")
+	if err != nil {
+		return err
+	}
+
+	rank := GetRandomSheetRank()
+	eventSheet := &EventSheet{event.ID, rank, NonReservedNum, event.Price + DataSet.SheetKindMap[rank].Price}
+	reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+	if err != nil {
+		return err
+	}
+
+	// A public event can not be closed directly
+	event.PublicFg = false
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを編集できること",
+		PostJSON:           eventEditJSON(event),
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	event.ClosedFg = true
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントをクローズできること",
+		PostJSON:           eventEditJSON(event),
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	canceled := false
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:      "DELETE",
+		Path:        fmt.Sprintf("/api/events/%d/sheets/%s/%d/reservation", event.ID, reservation.SheetRank, reservation.SheetNum),
+		Description: "クローズしたイベントの予約のキャンセルが仕様通りに扱われること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			switch res.StatusCode {
+			case 204:
+				canceled = true
+				if parameter.CloseFreezesCancel {
+					return fatalErrorf("クローズしたイベント(id:%d)の予約がキャンセルできてしまいます", event.ID)
+				}
+				return nil
+			case 404:
+				if !parameter.CloseFreezesCancel {
+					return fatalErrorf("クローズしたイベント(id:%d)の予約がキャンセルできません", event.ID)
+				}
+				return checkJsonErrorResponse("invalid_event")(res, body)
+			}
+			return fmt.Errorf("期待していないステータスコード %d", res.StatusCode)
+		},
+	})
+	// Keep the state as the webapp actually did
+	if canceled {
+		logID := state.BeginCancelation(user, reservation)
+		state.CommitCancelation(logID, user, reservation)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var reportHeader = []string{"reservation_id", "event_id", "rank", "num", "price", "user_id", "sold_at", "canceled_at"}
+
+// Positions in a row of each column of reportHeader
+type reportColumns []int
+
+// Reorders a row into the order of reportHeader
+func (cols reportColumns) canonical(row []string) []string {
+	ordered := make([]string, len(cols))
+	for i, j := range cols {
+		ordered[i] = row[j]
+	}
+	return ordered
+}
+
+// Checks the header of a report and returns positions of columns.
+// Columns must be in the order of reportHeader unless parameter.ReportColumnsByName is set.
+func checkReportHeader(reader *csv.Reader) (reportColumns, error) {
+	// reservation_id,event_id,rank,num,price,user_id,sold_at,canceled_at
+	row, err := reader.Read()
+	if err == io.EOF || len(row) != len(reportHeader) {
+		return nil, fatalErrorf("正しいCSVヘッダを取得できません")
+	}
+
+	positions := map[string]int{}
+	for i, name := range row {
+		positions[name] = i
+	}
+
+	cols := make(reportColumns, len(reportHeader))
+	for i, name := range reportHeader {
+		j, ok := positions[name]
+		if !ok || (!parameter.ReportColumnsByName && i != j) {
+			return nil, fatalErrorf("正しいCSVヘッダを取得できません")
+		}
+		cols[i] = j
+	}
+
+	// Every data row must have the same columns as the header, extra trailing fields are not tolerated
+	reader.FieldsPerRecord = len(reportHeader)
+	return cols, nil
+}
+
+func getReportRecords(s *State, reader *csv.Reader, cols reportColumns) (map[uint]*ReportRecord, error) {
+	// reservation_id,event_id,rank,num,price,user_id,sold_at,canceled_at
+	// 1,1,S,36,8000,1002,2018-08-17T04:55:30Z,2018-08-17T04:58:31Z
+	// 2,1,S,36,8000,1002,2018-08-17T04:55:32Z,
+	// 3,1,B,149,4000,1002,2018-08-17T04:55:33Z,
+	// 4,1,C,317,3000,1002,2018-08-17T04:55:34Z,
+	// 5,1,B,27,4000,1002,2018-08-17T04:55:36Z,
+	// 6,3,A,15,6000,1002,2018-08-17T04:55:38Z,
+	// 7,3,S,10,8000,1002,2018-08-17T04:55:41Z,2018-08-17T04:58:29Z
+
+	records := map[uint]*ReportRecord{}
+
+	// A broken row at the end of the body means that the response was cut off
+	const truncatedMsg = "レポートが途中で切れています"
+	isLastRow := func() bool {
+		_, err := reader.Read()
+		return err == io.EOF
+	}
+
+	var prevReservationID uint
+	line := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err == io.ErrUnexpectedEOF {
+			// The connection was closed in the middle of a streamed body
+			log.Printf("debug: body is cut off (line:%d) error:%v\n", line, err)
+			return nil, fatalErrorf(truncatedMsg)
+		}
+
+		msg := "正しいCSVレポートを取得できません"
+
+		if len(row) != len(reportHeader) {
+			log.Printf("debug: %d fields (line:%d) %v error:%v\n", len(row), line, row, err)
+			// Only a short row can be cut off, an extra field is a serialization bug
+			if len(row) < len(reportHeader) && isLastRow() {
+				log.Printf("debug: truncated row (line:%d) %v error:%v\n", line, row, err)
+				return nil, fatalErrorf(truncatedMsg)
+			}
+			return nil, fatalErrorf(msg)
+		}
+		row = cols.canonical(row)
+
+		reservationID, err := strconv.Atoi(row[0])
+		if err != nil {
+			log.Printf("debug: invalid reservationID (line:%d) error:%v\n", line, err)
+			return nil, fatalErrorf(msg)
+		}
+		eventID, err := strconv.Atoi(row[1])
+		if err != nil {
+			log.Printf("debug: invalid eventID (line:%d) error:%v\n", line, err)
+			return nil, fatalErrorf(msg)
+		}
+		sheetRank := row[2]
+
+		sheetNum, err := strconv.Atoi(row[3])
+		if err != nil {
+			log.Printf("debug: invalid sheetNum (line:%d) error:%v\n", line, err)
+			return nil, fatalErrorf(msg)
+		}
+		if sheetNum < 0 || !ValidSheetNum(sheetRank, uint(sheetNum)) {
+			log.Printf("debug: sheetNum %s-%d is out of range (line:%d)\n", sheetRank, sheetNum, line)
+			return nil, fatalErrorf("レポートのシート番号が正しくありません (line:%d)", line)
+		}
+
+		sheetPrice, err := strconv.Atoi(row[4])
+		if err != nil {
+			log.Printf("debug: invalid price (line:%d) error:%v\n", line, err)
+			return nil, fatalErrorf(msg)
+		}
+
+		userID, err := strconv.Atoi(row[5])
+		if err != nil {
+			log.Printf("debug: invalid userID (line:%d) error:%v\n", line, err)
+			return nil, fatalErrorf(msg)
+		}
+
+		soldAt, err := time.Parse(time.RFC3339, row[6])
+		if err != nil {
+			log.Printf("debug: invalid soldAt (line:%d) error:%v\n", line, err)
+			if isLastRow() {
+				return nil, fatalErrorf(truncatedMsg)
+			}
+			return nil, fatalErrorf(msg)
+		}
+		if parameter.StrictReportUTC && !strings.HasSuffix(row[6], "Z") {
+			log.Printf("debug: soldAt is not in UTC (line:%d) %s\n", line, row[6])
+			return nil, fatalErrorf("レポートの時刻がUTCではありません")
+		}
+
+		var canceledAt time.Time
+		if row[7] != "" {
+			canceledAt, err = time.Parse(time.RFC3339, row[7])
+			if err != nil {
+				log.Printf("debug: invalid canceledAt (line:%d) error:%v\n", line, err)
+				if isLastRow() {
+					return nil, fatalErrorf(truncatedMsg)
+				}
+				return nil, fatalErrorf(msg)
+			}
+			if parameter.StrictReportUTC && !strings.HasSuffix(row[7], "Z") {
+				log.Printf("debug: canceledAt is not in UTC (line:%d) %s\n", line, row[7])
+				return nil, fatalErrorf("レポートの時刻がUTCではありません")
+			}
+			// NOTE: Equal is acceptable because RFC3339 truncates sub-seconds
+			if canceledAt.Before(soldAt) {
+				log.Printf("debug: canceledAt %s is before soldAt %s (line:%d)\n", row[7], row[6], line)
+				return nil, fatalErrorf("正しいレポートを取得できません")
+			}
+		}
+
+		record := &ReportRecord{
+			ReservationID: uint(reservationID),
+			EventID:       uint(eventID),
+			SheetRank:     sheetRank,
+			SheetNum:      uint(sheetNum),
+			SheetPrice:    uint(sheetPrice),
+			UserID:        uint(userID),
+			SoldAt:        soldAt,
+			CanceledAt:    canceledAt,
+		}
+
+		if parameter.StrictReportOrder && record.ReservationID < prevReservationID {
+			log.Printf("debug: reservation_id=%d comes after %d (line:%d)\n", record.ReservationID, prevReservationID, line)
+			return nil, fatalErrorf("レポートがreservation_id順に並んでいません (line:%d)", line)
+		}
+		prevReservationID = record.ReservationID
+
+		// Duplicated rows may be within the bounds of the count check
+		if _, ok := records[record.ReservationID]; ok {
+			log.Printf("debug: reservation_id=%d is duplicated (line:%d)\n", record.ReservationID, line)
+			return nil, fatalErrorf("レポートのreservation_idが重複しています (line:%d)", line)
+		}
+		records[record.ReservationID] = record
+	}
+
+	return records, nil
+}
+
+// Reservation IDs are assigned in the order of creation, so sold_at should not go backwards as reservation_id increases.
+// Only warns because the clocks of multiple webapp servers may be skewed.
+func warnReportSoldAtBackwards(records map[uint]*ReportRecord) {
+	threshold := parameter.ReportSoldAtBackwardsThreshold
+	if threshold <= 0 || len(records) < 2 {
+		return
+	}
+
+	ids := make([]uint, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	backwards := 0
+	for i := 1; i < len(ids); i++ {
+		prev, cur := records[ids[i-1]], records[ids[i]]
+		if prev.SoldAt.Sub(cur.SoldAt) <= threshold {
+			continue
+		}
+		if backwards == 0 {
+			log.Printf("warn: sold_at of reservation_id=%d (%s) is earlier than the one of %d (%s)\n", cur.ReservationID, cur.SoldAt, prev.ReservationID, prev.SoldAt)
+		}
+		backwards++
+	}
+	if backwards > 0 {
+		log.Printf("warn: sold_at went backwards %d times in the report\n", backwards)
+		counter.AddKey("report-sold-at-backwards", backwards)
+	}
+}
+
+func checkReportRecord(s *State, records map[uint]*ReportRecord, timeBefore time.Time,
+	reservationsBeforeRequest map[uint]*Reservation) error {
+
+	warnReportSoldAtBackwards(records)
+
+	for reservationID, reservationBeforeRequest := range reservationsBeforeRequest {
+		// All elements in reservationsBeforeRequest must exist in records
+		record, ok := records[reservationID]
+		if !ok {
+			log.Printf("debug: should exist (reservationID:%d)\n", reservationID)
+			return fatalErrorf("レポートに予約id:%dの行が存在しません", reservationID)
+		}
+
+		event := s.FindEventByID(record.EventID)
+		if event == nil {
+			log.Printf("debug: event id=%d is not found (reservationID:%d)\n", record.EventID, reservationID)
+			return fatalErrorf("レポート(予約id:%d)のイベントidが正しくありません", reservationID)
+		}
+		if expected := event.Price + GetSheetKindByRank(record.SheetRank).Price; record.SheetPrice != expected {
+			log.Printf("debug: price:%d is not expected:%d (reservationID:%d)\n", record.SheetPrice, expected, reservationID)
+			return fatalErrorf("レポート(予約id:%d)のシート価格が正しくありません", reservationID)
+		}
+
+		if reservationBeforeRequest.EventID != record.EventID {
+			log.Printf("debug: event id=%d is not expected:%d (reservationID:%d)\n", record.EventID, reservationBeforeRequest.EventID, reservationID)
+			return fatalErrorf("レポート(予約id:%d)のイベントidが正しくありません", reservationID)
+		}
+		if reservationBeforeRequest.UserID != record.UserID {
+			log.Printf("debug: user id=%d is not expected:%d (reservationID:%d)\n", record.UserID, reservationBeforeRequest.UserID, reservationID)
+			return fatalErrorf("レポート(予約id:%d)のユーザidが正しくありません", reservationID)
+		}
+		if reservationBeforeRequest.SheetRank != record.SheetRank {
+			log.Printf("debug: sheet rank=%s is not expected:%s (reservationID:%d)\n", record.SheetRank, reservationBeforeRequest.SheetRank, reservationID)
+			return fatalErrorf("レポート(予約id:%d)のシートランクが正しくありません", reservationID)
+		}
+		if reservationBeforeRequest.SheetNum != record.SheetNum {
+			log.Printf("debug: sheet num=%d is not expected:%d (reservationID:%d)\n", record.SheetNum, reservationBeforeRequest.SheetNum, reservationID)
+			return fatalErrorf("レポート(予約id:%d)のシート番号が正しくありません", reservationID)
+		}
+
+		if reservationBeforeRequest.Canceled(timeBefore) {
+			if record.CanceledAt.IsZero() {
+				log.Printf("debug: should have canceledAt (reservationID:%d)\n", reservationID)
+				return fatalErrorf("レポート(予約id:%d)のキャンセル時刻が正しくありません", reservationID)
+			}
+		} else if reservationBeforeRequest.MaybeCanceled(timeBefore) {
+			if record.CanceledAt.IsZero() {
+				log.Printf("warn: should have canceledAt (reservationID:%d) but ignored (race condition)\n", reservationID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks that the report does not contain reservations the benchmarker never made.
+// Reservations of in-flight or timed out requests are unknown yet, so up to pending of them are tolerated.
+func checkReportUnknownReservations(s *State, records map[uint]*ReportRecord, pending int) error {
+	if !parameter.StrictReportReservations {
+		return nil
+	}
+
+	reservationsAfterResponse := s.GetReservations()
+	var unknownIDs []uint
+	for reservationID := range records {
+		if _, ok := reservationsAfterResponse[reservationID]; !ok {
+			unknownIDs = append(unknownIDs, reservationID)
+		}
+	}
+	if len(unknownIDs) > pending {
+		log.Printf("debug: unknown reservations %v exceed pending reserve requests:%d\n", unknownIDs, pending)
+		return fatalErrorf("レポートに存在しない予約(予約id:%d)が含まれています", unknownIDs[0])
+	}
+	return nil
+}
+
+func checkReportCount(
+	reserveCompletedCountBeforeRequest int,
+	reportCount int,
+	reserveRequestedCountAfterResponse uint) error {
+	log.Printf("debug: reserveCompletedCountBeforeRequest:%d <= reportCount:%d <= reserveRequestedCountAfterResponse:%d\n",
+		reserveCompletedCountBeforeRequest,
+		reportCount,
+		reserveRequestedCountAfterResponse)
+	if reserveCompletedCountBeforeRequest <= reportCount &&
+		reportCount <= int(reserveRequestedCountAfterResponse) {
+		return nil
+	}
+	return fatalErrorf("レポートの数が正しくありません")
+}
+
+// Reads the report from the stream not to buffer the whole of a large report
+func checkReportResponse(s *State, timeBefore time.Time, reservationsBeforeRequest map[uint]*Reservation) func(res *http.Response, body io.Reader) error {
+	return func(res *http.Response, body io.Reader) error {
+		reader := csv.NewReader(body)
+
+		cols, err := checkReportHeader(reader)
+		if err != nil {
+			return err
+		}
+
+		records, err := getReportRecords(s, reader, cols)
+		if err != nil {
+			return err
+		}
+
+		// The response completes when the whole stream is read
+		reserveRequestedCountAfterResponse := s.GetReserveRequestedCount()
+		pendingReserveCount := s.GetReserveLogCount()
+
+		err = checkReportRecord(s, records, timeBefore, reservationsBeforeRequest)
+		if err != nil {
+			return err
+		}
+
+		err = checkReportUnknownReservations(s, records, pendingReserveCount)
+		if err != nil {
+			return err
+		}
+
+		err = checkReportCount(len(reservationsBeforeRequest), len(records), reserveRequestedCountAfterResponse)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func checkEventReportResponse(s *State, event *Event, timeBefore time.Time, reservationsBeforeRequest map[uint]*Reservation) func(res *http.Response, body *bytes.Buffer) error {
+	return func(res *http.Response, body *bytes.Buffer) error {
+		reserveRequestedCountAfterResponse := event.GetReserveRequestedCount()
+		pendingReserveCount := s.GetReserveLogCount()
+
+		log.Printf("debug: checkEventReport %d\n", event.ID)
+		log.Println("debug:", body)
+		reader := csv.NewReader(body)
+
+		cols, err := checkReportHeader(reader)
+		if err != nil {
+			return err
+		}
+
+		records, err := getReportRecords(s, reader, cols)
+		if err != nil {
+			return err
+		}
+
+		msg := "正しいレポートを取得できません"
+		for _, record := range records {
+			if record.EventID != event.ID {
+				log.Printf("debug: event id=%d does not match with id=%d (reservationID:%d)\n", record.EventID, event.ID, record.ReservationID)
+				return fatalErrorf(msg)
+			}
+		}
+
+		err = checkReportRecord(s, records, timeBefore, reservationsBeforeRequest)
+		if err != nil {
+			return err
+		}
+
+		err = checkReportUnknownReservations(s, records, pendingReserveCount)
+		if err != nil {
+			return err
+		}
+
+		err = checkReportCount(len(reservationsBeforeRequest), len(records), reserveRequestedCountAfterResponse)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func CheckReport(ctx context.Context, state *State) error {
+	admin, checker, push := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer push()
+
+	err := loginAdministratorWithTimeout(ctx, checker, admin, parameter.PostTestLoginTimeout)
+	if err != nil {
+		return err
+	}
+
+	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
+	reservationsBeforeRequest := FilterReservationsToAllowDelay(state.GetCopiedReservations(), timeBefore)
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/admin/api/reports/sales",
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		StreamCheckFunc:    checkReportResponse(state, timeBefore, reservationsBeforeRequest),
+		Timeout:            parameter.PostTestReportTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns records grouped by user_id
+func groupReportRecordsByUser(records map[uint]*ReportRecord) map[uint][]*ReportRecord {
+	byUser := map[uint][]*ReportRecord{}
+	for _, record := range records {
+		byUser[record.UserID] = append(byUser[record.UserID], record)
+	}
+	return byUser
+}
+
+// マイページの合計金額が、レポートのそのユーザーのキャンセルされていない予約の合計金額と一致することを確認する
+// ユーザーを確保している間はそのユーザーの予約は増減しないので、両者は厳密に一致する
+func CheckReportMatchesMyPage(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministratorWithTimeout(ctx, adminChecker, admin, parameter.PostTestLoginTimeout)
+	if err != nil {
+		return err
+	}
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	var totalPrice uint
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/users/%d", user.ID),
+		ExpectedStatusCode: 200,
+		Description:        "マイページを取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			jsonUser := JsonFullUser{}
+			err := decodeJSON(body, &jsonUser)
+			if err != nil {
+				return err
+			}
+			totalPrice = jsonUser.TotalPrice
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var records map[uint]*ReportRecord
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/admin/api/reports/sales",
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		Timeout:            parameter.PostTestReportTimeout,
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			reader := csv.NewReader(body)
+
+			cols, err := checkReportHeader(reader)
+			if err != nil {
+				return err
+			}
+
+			records, err = getReportRecords(state, reader, cols)
+			return err
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var reportedCount int
+	var reportedPrice uint
+	for _, record := range groupReportRecordsByUser(records)[user.ID] {
+		if !record.CanceledAt.IsZero() {
+			continue
+		}
+		reportedCount++
+		reportedPrice += record.SheetPrice
+	}
+	if reportedPrice != totalPrice {
+		log.Printf("debug: total_price:%d of my page != %d of %d reservations in report (userID:%d)\n", totalPrice, reportedPrice, reportedCount, user.ID)
+		return fatalErrorf("マイページの合計金額がレポートと一致しません userID=%d", user.ID)
+	}
+
+	return nil
+}
+
+func CheckEventReport(ctx context.Context, state *State) error {
+	admin, checker, push := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer push()
+
+	err := loginAdministrator(ctx, checker, admin)
+	if err != nil {
+		return err
+	}
+
+	// We want to let webapp to lock reservations.
+	// Since no reserve/cancel occurs for closed events, we ignore closed events.
+	// Notice that webapp locks to update reservations (cancel),
+	// but it does not lock to create reservations (reserve).
+	event := state.GetRandomPublicEvent()
+	if event == nil {
+		return nil
+	}
+
+	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
+	reservationsBeforeRequest := FilterReservationsToAllowDelay(state.GetCopiedReservationsInEventID(event.ID), timeBefore)
+
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		CheckFunc:          checkEventReportResponse(state, event, timeBefore, reservationsBeforeRequest),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 予約したランクとレポートのランクが一致することを予約idで突き合わせて確認する
+func CheckReportSheetRank(ctx context.Context, state *State) error {
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	eventSheet, eventSheetPush, err := popOrCreateEventSheet(ctx, state)
+	if err != nil {
+		return err
+	}
+	if eventSheet == nil {
+		return nil
+	}
+
+	reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+	if reservation == nil && err == nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer eventSheetPush() // NOTE: push only after reserve succeeds
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err = loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", reservation.EventID),
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			reader := csv.NewReader(body)
+
+			cols, err := checkReportHeader(reader)
+			if err != nil {
+				return err
+			}
+
+			records, err := getReportRecords(state, reader, cols)
+			if err != nil {
+				return err
+			}
+
+			record, ok := records[reservation.ID]
+			if !ok {
+				return fatalErrorf("レポートに予約id:%dの行が存在しません", reservation.ID)
+			}
+			if record.SheetRank != reservation.SheetRank {
+				log.Printf("warn: sheet rank=%s is not reserved rank=%s (reservationID:%d)\n", record.SheetRank, reservation.SheetRank, reservation.ID)
+				return fatalErrorf("レポート(予約id:%d)のシートランクが予約したランクと異なります", reservation.ID)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func getEventReportRecords(ctx context.Context, state *State, adminChecker *Checker, eventID uint) (map[uint]*ReportRecord, error) {
+	var records map[uint]*ReportRecord
+	err := adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", eventID),
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			reader := csv.NewReader(body)
+
+			cols, err := checkReportHeader(reader)
+			if err != nil {
+				return err
+			}
+
+			records, err = getReportRecords(state, reader, cols)
+			return err
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// レポート取得後に作られた予約が、次のレポートに含まれることを確認する
+func CheckReportIncludesNewReservation(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReportIncludesNewReservation")
+	if err != nil {
+		return err
+	}
+
+	rank := GetRandomSheetRank()
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	firstRecords, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := reserveSheet(ctx, state, userChecker, user, &EventSheet{event.ID, rank, NonReservedNum, price})
+	if err != nil {
+		return err
+	}
+	if _, ok := firstRecords[reservation.ID]; ok {
+		return fatalErrorf("レポートに未来の予約id:%dの行が存在します", reservation.ID)
+	}
+
+	secondRecords, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secondRecords[reservation.ID]; !ok {
+		log.Printf("warn: reservation:%d made after the first report is not in the second report (eventID:%d)\n", reservation.ID, event.ID)
+		return fatalErrorf("レポートに予約id:%dの行が存在しません", reservation.ID)
+	}
+	for reservationID := range firstRecords {
+		if _, ok := secondRecords[reservationID]; !ok {
+			return fatalErrorf("レポートに予約id:%dの行が存在しません", reservationID)
+		}
+	}
+
+	return nil
+}
+
+// 予約してしばらく放置しても、勝手にキャンセルされたり消えたりしないことを確認する
+func CheckReservationNotExpired(ctx context.Context, state *State) error {
+	if parameter.StaleReservationCheckWait <= 0 {
+		return nil
+	}
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReservationNotExpired")
+	if err != nil {
+		return err
+	}
+
+	rank := GetRandomSheetRank()
+	price := event.Price + DataSet.SheetKindMap[rank].Price
+
+	reservation, err := reserveSheet(ctx, state, userChecker, user, &EventSheet{event.ID, rank, NonReservedNum, price})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(parameter.StaleReservationCheckWait):
+	case <-ctx.Done():
+		return nil
+	}
+
+	records, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
+	if err != nil {
+		return err
+	}
+	record, ok := records[reservation.ID]
+	if !ok {
+		log.Printf("warn: reservation:%d disappeared after %s\n", reservation.ID, parameter.StaleReservationCheckWait)
+		return fatalErrorf("レポートに予約id:%dの行が存在しません", reservation.ID)
+	}
+	if !record.CanceledAt.IsZero() {
+		log.Printf("warn: reservation:%d was canceled at %s without cancel request\n", reservation.ID, record.CanceledAt)
+		return fatalErrorf("キャンセルしていない予約(id:%d)がキャンセルされています", reservation.ID)
+	}
+
+	err = userChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/users/%d", user.ID),
+		ExpectedStatusCode: 200,
+		Description:        "放置した予約がマイページに表示されること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			jsonUser := JsonFullUser{}
+			err := decodeJSON(body, &jsonUser)
+			if err != nil {
+				return err
+			}
+			for _, r := range jsonUser.RecentReservations {
+				if r.ReservationID != reservation.ID {
+					continue
+				}
+				if r.CanceledAt != 0 {
+					return fatalErrorf("キャンセルしていない予約(id:%d)がキャンセルされています", reservation.ID)
+				}
+				return nil
+			}
+			return fatalErrorf("最近予約した席に予約id:%dが存在しません", reservation.ID)
+		},
+	})
+	if err != nil {
+		return err
+	}
 
-		msg := "正しいCSVレポートを取得できません"
+	return nil
+}
 
-		if len(row) != 8 {
-			return nil, fatalErrorf(msg)
-		}
+// 不正なイベントIDでのレポート取得がサーバエラーにならないことを確認する
+//
+//	/admin/api/reports/events/abc/sales => 404 not_found
+//	/admin/api/reports/events/-1/sales  => 404 not_found (only if parameter.ReportRejectsNegativeEventID)
+func CheckEventReportMalformedID(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
 
-		reservationID, err := strconv.Atoi(row[0])
-		if err != nil {
-			log.Printf("debug: invalid reservationID (line:%d) error:%v\n", line, err)
-			return nil, fatalErrorf(msg)
-		}
-		eventID, err := strconv.Atoi(row[1])
-		if err != nil {
-			log.Printf("debug: invalid eventID (line:%d) error:%v\n", line, err)
-			return nil, fatalErrorf(msg)
-		}
-		sheetRank := row[2]
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
 
-		sheetNum, err := strconv.Atoi(row[3])
+	paths := []string{"/admin/api/reports/events/abc/sales"}
+	if parameter.ReportRejectsNegativeEventID {
+		paths = append(paths, "/admin/api/reports/events/-1/sales")
+	}
+
+	for _, path := range paths {
+		err = adminChecker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               path,
+			ExpectedStatusCode: 404,
+			Description:        "不正なイベントIDの場合レポートの取得に失敗すること",
+			CheckFunc:          checkJsonErrorResponse("not_found"),
+		})
 		if err != nil {
-			log.Printf("debug: invalid sheetNum (line:%d) error:%v\n", line, err)
-			return nil, fatalErrorf(msg)
+			return err
 		}
+	}
 
-		sheetPrice, err := strconv.Atoi(row[4])
-		if err != nil {
-			log.Printf("debug: invalid price (line:%d) error:%v\n", line, err)
-			return nil, fatalErrorf(msg)
+	return nil
+}
+
+// 複数のユーザーの予約がそれぞれ正しいユーザーIDでレポートに含まれることを確認する
+func CheckReportManyUsers(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReportManyUsers")
+	if err != nil {
+		return err
+	}
+
+	type booking struct {
+		user        *AppUser
+		checker     *Checker
+		eventSheet  *EventSheet
+		reservation *Reservation
+	}
+	var bookings []*booking
+	for i := 0; i < parameter.ReportManyUsersCount; i++ {
+		user, userChecker, userPush := state.PopRandomUser()
+		if user == nil {
+			break
 		}
+		defer userPush()
 
-		userID, err := strconv.Atoi(row[5])
+		err := loginAppUser(ctx, userChecker, user)
 		if err != nil {
-			log.Printf("debug: invalid userID (line:%d) error:%v\n", line, err)
-			return nil, fatalErrorf(msg)
+			return err
 		}
 
-		_, err = time.Parse(time.RFC3339, row[6])
+		rank := GetRandomSheetRank()
+		eventSheet := &EventSheet{event.ID, rank, NonReservedNum, event.Price + DataSet.SheetKindMap[rank].Price}
+		reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
 		if err != nil {
-			log.Printf("debug: invalid soldAt (line:%d) error:%v\n", line, err)
-			return nil, fatalErrorf(msg)
+			return err
 		}
+		bookings = append(bookings, &booking{user, userChecker, eventSheet, reservation})
+	}
 
-		var canceledAt time.Time
-		if row[7] != "" {
-			canceledAt, err = time.Parse(time.RFC3339, row[7])
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               "/admin/api/reports/sales",
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		Timeout:            parameter.PostTestReportTimeout,
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			reader := csv.NewReader(body)
+
+			cols, err := checkReportHeader(reader)
 			if err != nil {
-				log.Printf("debug: invalid canceledAt (line:%d) error:%v\n", line, err)
-				return nil, fatalErrorf(msg)
+				return err
 			}
-		}
 
-		record := &ReportRecord{
-			ReservationID: uint(reservationID),
-			EventID:       uint(eventID),
-			SheetRank:     sheetRank,
-			SheetNum:      uint(sheetNum),
-			SheetPrice:    uint(sheetPrice),
-			UserID:        uint(userID),
-			CanceledAt:    canceledAt,
-		}
+			records, err := getReportRecords(state, reader, cols)
+			if err != nil {
+				return err
+			}
 
-		records[record.ReservationID] = record
+			for _, b := range bookings {
+				record, ok := records[b.reservation.ID]
+				if !ok {
+					return fatalErrorf("レポートに予約id:%dの行が存在しません", b.reservation.ID)
+				}
+				if record.UserID != b.user.ID {
+					log.Printf("warn: user id=%d is not expected:%d (reservationID:%d)\n", record.UserID, b.user.ID, b.reservation.ID)
+					return fatalErrorf("レポート(予約id:%d)のユーザidが正しくありません", b.reservation.ID)
+				}
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
 	}
 
-	return records, nil
+	for _, b := range bookings {
+		_, err := cancelSheet(ctx, state, b.checker, b.user, b.eventSheet, b.reservation)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func checkReportRecord(s *State, records map[uint]*ReportRecord, timeBefore time.Time,
-	reservationsBeforeRequest map[uint]*Reservation) error {
+// イベント詳細の残席数とイベントのレポートの予約数が一致することを確認する
+func CheckEventDetailMatchesReport(ctx context.Context, state *State) error {
+	checker := NewChecker()
 
-	for reservationID, reservationBeforeRequest := range reservationsBeforeRequest {
-		// All elements in reservationsBeforeRequest must exist in records
-		record, ok := records[reservationID]
-		if !ok {
-			log.Printf("debug: should exist (reservationID:%d)\n", reservationID)
-			return fatalErrorf("レポートに予約id:%dの行が存在しません", reservationID)
-		}
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
 
-		event := s.FindEventByID(record.EventID)
-		if event == nil {
-			log.Printf("debug: event id=%d is not found (reservationID:%d)\n", record.EventID, reservationID)
-			return fatalErrorf("レポート(予約id:%d)のイベントidが正しくありません", reservationID)
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	event := state.GetRandomPublicEvent()
+	if event == nil {
+		return nil
+	}
+
+	_, reserveCompletedBefore, cancelRequestedBefore, _ := event.GetReservationTickets()
+	reservationsBeforeRequest := state.GetCopiedReservationsInEventID(event.ID)
+
+	var jsonEvent JsonEvent
+	err = checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "公開イベントを取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			return decodeJSON(body, &jsonEvent)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	records, err := getEventReportRecords(ctx, state, adminChecker, event.ID)
+	if err != nil {
+		return err
+	}
+
+	reserveRequestedAfter, _, _, cancelCompletedAfter := event.GetReservationTickets()
+	quiet := reserveRequestedAfter == reserveCompletedBefore && cancelRequestedBefore == cancelCompletedAfter
+
+	reportReserved := map[string]int{}
+	for _, record := range records {
+		if record.CanceledAt.IsZero() {
+			reportReserved[record.SheetRank]++
 		}
-		if expected := event.Price + GetSheetKindByRank(record.SheetRank).Price; record.SheetPrice != expected {
-			log.Printf("debug: price:%d is not expected:%d (reservationID:%d)\n", record.SheetPrice, expected, reservationID)
-			return fatalErrorf("レポート(予約id:%d)のシート価格が正しくありません", reservationID)
+	}
+	stateReserved := map[string]int{}
+	for _, reservation := range reservationsBeforeRequest {
+		if reservation.CancelRequestedAt.IsZero() {
+			stateReserved[reservation.SheetRank]++
 		}
+	}
 
-		if reservationBeforeRequest.EventID != record.EventID {
-			log.Printf("debug: event id=%d is not expected:%d (reservationID:%d)\n", record.EventID, reservationBeforeRequest.EventID, reservationID)
-			return fatalErrorf("レポート(予約id:%d)のイベントidが正しくありません", reservationID)
+	var sumLower, sumUpper, sumDetail, sumReport int
+	for _, sheetKind := range DataSet.SheetKinds {
+		rank := sheetKind.Rank
+		sheet, ok := jsonEvent.Sheets[rank]
+		if !ok || sheet.Total != sheetKind.Total || sheet.Remains > sheet.Total {
+			return fatalErrorf("イベント(id:%d)のシート数が正しくありません", event.ID)
 		}
-		if reservationBeforeRequest.UserID != record.UserID {
-			log.Printf("debug: user id=%d is not expected:%d (reservationID:%d)\n", record.UserID, reservationBeforeRequest.UserID, reservationID)
-			return fatalErrorf("レポート(予約id:%d)のユーザidが正しくありません", reservationID)
+
+		// Reservations which exist certainly / possibly during the requests
+		lower := int(reserveCompletedBefore.Get(rank)) - int(cancelRequestedBefore.Get(rank))
+		upper := int(reserveRequestedAfter.Get(rank)) - int(cancelCompletedAfter.Get(rank))
+		detail := int(sheet.Total - sheet.Remains)
+		report := reportReserved[rank]
+
+		if n := stateReserved[rank]; n < lower || upper < n {
+			log.Printf("warn: benchmarker has %d reservations of %s-rank out of [%d, %d] (eventID:%d)\n", n, rank, lower, upper, event.ID)
 		}
-		if reservationBeforeRequest.SheetRank != record.SheetRank {
-			log.Printf("debug: sheet rank=%s is not expected:%s (reservationID:%d)\n", record.SheetRank, reservationBeforeRequest.SheetRank, reservationID)
-			return fatalErrorf("レポート(予約id:%d)のシートランクが正しくありません", reservationID)
+		if detail < lower || upper < detail || report < lower || upper < report {
+			log.Printf("warn: %s-rank detail:%d report:%d are out of [%d, %d] (eventID:%d)\n", rank, detail, report, lower, upper, event.ID)
+			return fatalErrorf("イベント(id:%d)の%sランクの予約数がイベント詳細とレポートで一致しません", event.ID, rank)
 		}
-		if reservationBeforeRequest.SheetNum != record.SheetNum {
-			log.Printf("debug: sheet num=%d is not expected:%d (reservationID:%d)\n", record.SheetNum, reservationBeforeRequest.SheetNum, reservationID)
-			return fatalErrorf("レポート(予約id:%d)のシート番号が正しくありません", reservationID)
+		if quiet && detail != report {
+			log.Printf("warn: %s-rank detail:%d != report:%d (eventID:%d)\n", rank, detail, report, event.ID)
+			return fatalErrorf("イベント(id:%d)の%sランクの予約数がイベント詳細とレポートで一致しません", event.ID, rank)
 		}
 
-		if reservationBeforeRequest.Canceled(timeBefore) {
-			if record.CanceledAt.IsZero() {
-				log.Printf("debug: should have canceledAt (reservationID:%d)\n", reservationID)
-				return fatalErrorf("レポート(予約id:%d)のキャンセル時刻が正しくありません", reservationID)
+		sumLower += lower
+		sumUpper += upper
+		sumDetail += detail
+		sumReport += report
+	}
+
+	if int(jsonEvent.Total-jsonEvent.Remains) != sumDetail {
+		return fatalErrorf("イベント(id:%d)の残席数が各ランクの残席数の合計と一致しません", event.ID)
+	}
+	if sumReport < sumLower || sumUpper < sumReport || (quiet && sumDetail != sumReport) {
+		log.Printf("warn: detail:%d report:%d [%d, %d] (eventID:%d)\n", sumDetail, sumReport, sumLower, sumUpper, event.ID)
+		return fatalErrorf("イベント(id:%d)の予約数がイベント詳細とレポートで一致しません", event.ID)
+	}
+
+	return nil
+}
+
+// あるイベントの予約が別のイベントのレポートに含まれないことを確認する
+func CheckEventReportIsolation(ctx context.Context, state *State) error {
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
+	}
+	defer userPush()
+
+	err := loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
+
+	eventSheet, eventSheetPush, err := popOrCreateEventSheet(ctx, state)
+	if err != nil {
+		return err
+	}
+	if eventSheet == nil {
+		return nil
+	}
+
+	reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+	if reservation == nil && err == nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer eventSheetPush() // NOTE: push only after reserve succeeds
+
+	// NOTE: eventSheets are ordered by event, so popping another sheet mostly returns the same event.
+	var otherEvent *Event
+	for retry := 0; retry < 5; retry++ {
+		e := state.GetRandomPublicEvent()
+		if e != nil && e.ID != reservation.EventID {
+			otherEvent = e
+			break
+		}
+	}
+	if otherEvent == nil {
+		return nil
+	}
+
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
+
+	err = loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", otherEvent.ID),
+		ExpectedStatusCode: 200,
+		Description:        "レポートを正しく取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			reader := csv.NewReader(body)
+
+			cols, err := checkReportHeader(reader)
+			if err != nil {
+				return err
 			}
-		} else if reservationBeforeRequest.MaybeCanceled(timeBefore) {
-			if record.CanceledAt.IsZero() {
-				log.Printf("warn: should have canceledAt (reservationID:%d) but ignored (race condition)\n", reservationID)
+
+			records, err := getReportRecords(state, reader, cols)
+			if err != nil {
+				return err
 			}
-		}
+
+			if _, ok := records[reservation.ID]; ok {
+				log.Printf("warn: reservationID:%d of eventID:%d is found in the report of eventID:%d\n", reservation.ID, reservation.EventID, otherEvent.ID)
+				return fatalErrorf("イベント(id:%d)のレポートに別のイベントの予約id:%dが含まれています", otherEvent.ID, reservation.ID)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func checkReportCount(
-	reserveCompletedCountBeforeRequest int,
-	reportCount int,
-	reserveRequestedCountAfterResponse uint) error {
-	log.Printf("debug: reserveCompletedCountBeforeRequest:%d <= reportCount:%d <= reserveRequestedCountAfterResponse:%d\n",
-		reserveCompletedCountBeforeRequest,
-		reportCount,
-		reserveRequestedCountAfterResponse)
-	if reserveCompletedCountBeforeRequest <= reportCount &&
-		reportCount <= int(reserveRequestedCountAfterResponse) {
+// イベントの予約/キャンセルを繰り返しながら同じイベントのレポートを取得して、
+// レポートのロックと予約/キャンセルがデッドロックしないことを確認する
+func CheckReportLockContention(ctx context.Context, state *State) error {
+	duration := parameter.ReportLockCheckDuration
+	if duration <= 0 {
 		return nil
 	}
-	return fatalErrorf("レポートの数が正しくありません")
-}
-
-func checkReportResponse(s *State, timeBefore time.Time, reservationsBeforeRequest map[uint]*Reservation) func(res *http.Response, body *bytes.Buffer) error {
-	return func(res *http.Response, body *bytes.Buffer) error {
-		reserveRequestedCountAfterResponse := s.GetReserveRequestedCount()
-
-		log.Println("debug:", body)
-		reader := csv.NewReader(body)
 
-		err := checkReportHeader(reader)
-		if err != nil {
-			return err
-		}
-
-		records, err := getReportRecords(s, reader)
-		if err != nil {
-			return err
-		}
-
-		err = checkReportRecord(s, records, timeBefore, reservationsBeforeRequest)
-		if err != nil {
-			return err
-		}
-
-		err = checkReportCount(len(reservationsBeforeRequest), len(records), reserveRequestedCountAfterResponse)
-		if err != nil {
-			return err
-		}
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
+	if admin == nil {
+		return nil
+	}
+	defer adminPush()
 
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
 		return nil
 	}
-}
+	defer userPush()
 
-func checkEventReportResponse(s *State, event *Event, timeBefore time.Time, reservationsBeforeRequest map[uint]*Reservation) func(res *http.Response, body *bytes.Buffer) error {
-	return func(res *http.Response, body *bytes.Buffer) error {
-		reserveRequestedCountAfterResponse := event.GetReserveRequestedCount()
+	err := loginAdministrator(ctx, adminChecker, admin)
+	if err != nil {
+		return err
+	}
 
-		log.Printf("debug: checkEventReport %d\n", event.ID)
-		log.Println("debug:", body)
-		reader := csv.NewReader(body)
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
+	}
 
-		err := checkReportHeader(reader)
-		if err != nil {
-			return err
-		}
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckReportLockContention")
+	if err != nil {
+		return err
+	}
 
-		records, err := getReportRecords(s, reader)
-		if err != nil {
-			return err
-		}
+	// NOTE: Do not cancel ctx at the deadline, otherwise in-flight requests are recorded as timeouts.
+	deadline := time.Now().Add(duration)
 
-		msg := "正しいレポートを取得できません"
-		for _, record := range records {
-			if record.EventID != event.ID {
-				log.Printf("debug: event id=%d does not match with id=%d (reservationID:%d)\n", record.EventID, event.ID, record.ReservationID)
-				return fatalErrorf(msg)
+	errCh := make(chan error, 1)
+	go func() {
+		for time.Now().Before(deadline) && ctx.Err() == nil {
+			rank := GetRandomSheetRank()
+			eventSheet := &EventSheet{event.ID, rank, NonReservedNum, event.Price + DataSet.SheetKindMap[rank].Price}
+			reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			_, err = cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
+			if err != nil {
+				errCh <- err
+				return
 			}
 		}
+		errCh <- nil
+	}()
 
-		err = checkReportRecord(s, records, timeBefore, reservationsBeforeRequest)
-		if err != nil {
-			return err
-		}
+	var reportErr error
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
+		reservationsBeforeRequest := FilterReservationsToAllowDelay(state.GetCopiedReservationsInEventID(event.ID), timeBefore)
 
-		err = checkReportCount(len(reservationsBeforeRequest), len(records), reserveRequestedCountAfterResponse)
-		if err != nil {
-			return err
+		reportErr = adminChecker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", event.ID),
+			ExpectedStatusCode: 200,
+			Description:        "レポートを正しく取得できること",
+			CheckFunc:          checkEventReportResponse(state, event, timeBefore, reservationsBeforeRequest),
+		})
+		if reportErr != nil {
+			break
 		}
+	}
 
-		return nil
+	err = <-errCh
+	if IsCheckerTimeout(reportErr) || IsCheckerTimeout(err) {
+		return fatalErrorf("レポートの取得と予約/キャンセルがデッドロックしている可能性があります(id:%d)", event.ID)
 	}
+	if reportErr != nil {
+		return reportErr
+	}
+	return err
 }
 
-func CheckReport(ctx context.Context, state *State) error {
-	admin, checker, push := state.PopRandomAdministrator()
+// 予約の大半がキャンセルされたイベントのレポートが正しいことを確認する
+func CheckEventReportManyCancels(ctx context.Context, state *State) error {
+	admin, adminChecker, adminPush := state.PopRandomAdministrator()
 	if admin == nil {
 		return nil
 	}
-	defer push()
+	defer adminPush()
 
-	err := loginAdministratorWithTimeout(ctx, checker, admin, parameter.PostTestLoginTimeout)
-	if err != nil {
-		return err
+	user, userChecker, userPush := state.PopRandomUser()
+	if user == nil {
+		return nil
 	}
+	defer userPush()
 
-	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
-	reservationsBeforeRequest := FilterReservationsToAllowDelay(state.GetCopiedReservations(), timeBefore)
-
-	err = checker.Play(ctx, &CheckAction{
-		Method:             "GET",
-		Path:               "/admin/api/reports/sales",
-		ExpectedStatusCode: 200,
-		Description:        "レポートを正しく取得できること",
-		CheckFunc:          checkReportResponse(state, timeBefore, reservationsBeforeRequest),
-		Timeout:            parameter.PostTestReportTimeout,
-	})
+	err := loginAdministrator(ctx, adminChecker, admin)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func CheckEventReport(ctx context.Context, state *State) error {
-	admin, checker, push := state.PopRandomAdministrator()
-	if admin == nil {
-		return nil
+	err = loginAppUser(ctx, userChecker, user)
+	if err != nil {
+		return err
 	}
-	defer push()
 
-	err := loginAdministrator(ctx, checker, admin)
+	event, err := createDedicatedEvent(ctx, state, adminChecker, "CheckEventReportManyCancels")
 	if err != nil {
 		return err
 	}
 
-	// We want to let webapp to lock reservations.
-	// Since no reserve/cancel occurs for closed events, we ignore closed events.
-	// Notice that webapp locks to update reservations (cancel),
-	// but it does not lock to create reservations (reserve).
-	event := state.GetRandomPublicEvent()
-	if event == nil {
-		return nil
+	var reservations []*Reservation
+	for i := 0; i < parameter.ManyCancelsReservations; i++ {
+		rank := GetRandomSheetRank()
+		eventSheet := &EventSheet{event.ID, rank, NonReservedNum, event.Price + DataSet.SheetKindMap[rank].Price}
+		reservation, err := reserveSheet(ctx, state, userChecker, user, eventSheet)
+		if err != nil {
+			return err
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	remains := parameter.ManyCancelsRemains
+	if remains > len(reservations) {
+		remains = len(reservations)
+	}
+	for _, reservation := range reservations[remains:] {
+		eventSheet := &EventSheet{event.ID, reservation.SheetRank, reservation.SheetNum, reservation.Price}
+		_, err := cancelSheet(ctx, state, userChecker, user, eventSheet, reservation)
+		if err != nil {
+			return err
+		}
 	}
 
+	// Wait for the webapp to reflect all cancelations
+	time.Sleep(parameter.AllowableDelay)
+
 	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
 	reservationsBeforeRequest := FilterReservationsToAllowDelay(state.GetCopiedReservationsInEventID(event.ID), timeBefore)
 
-	err = checker.Play(ctx, &CheckAction{
+	err = adminChecker.Play(ctx, &CheckAction{
 		Method:             "GET",
 		Path:               fmt.Sprintf("/admin/api/reports/events/%d/sales", event.ID),
 		ExpectedStatusCode: 200,
-		Description:        "レポートを正しく取得できること",
-		CheckFunc:          checkEventReportResponse(state, event, timeBefore, reservationsBeforeRequest),
+		Description:        "キャンセルの多いイベントのレポートを正しく取得できること",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			data := append([]byte(nil), body.Bytes()...)
+
+			err := checkEventReportResponse(state, event, timeBefore, reservationsBeforeRequest)(res, body)
+			if err != nil {
+				return err
+			}
+
+			reader := csv.NewReader(bytes.NewReader(data))
+			cols, err := checkReportHeader(reader)
+			if err != nil {
+				return err
+			}
+			records, err := getReportRecords(state, reader, cols)
+			if err != nil {
+				return err
+			}
+
+			active := 0
+			for _, record := range records {
+				if record.CanceledAt.IsZero() {
+					active++
+				}
+			}
+			if active != remains {
+				log.Printf("warn: # of active reservations=%d is not expected=%d (eventID:%d)\n", active, remains, event.ID)
+				return fatalErrorf("レポート(イベントid:%d)のキャンセルされていない予約の数が正しくありません", event.ID)
+			}
+			return nil
+		},
 	})
 	if err != nil {
 		return err
@@ -2341,9 +5556,11 @@ func loginAdministrator(ctx context.Context, checker *Checker, admin *Administra
 
 func loginAdministratorWithTimeout(ctx context.Context, checker *Checker, admin *Administrator, timeout time.Duration) error {
 	if admin.Status.Online {
+		counter.IncKey("login-skipped")
 		return nil
 	}
 
+	defer observeLogin(time.Now())
 	err := checker.Play(ctx, &CheckAction{
 		Method:             "POST",
 		Path:               "/admin/api/actions/login",
@@ -2383,11 +5600,19 @@ func logoutAdministrator(ctx context.Context, checker *Checker, admin *Administr
 	return nil
 }
 
+// Records time spent for login separately from other actions, see printLoginSummary
+func observeLogin(start time.Time) {
+	counter.AddKey("login-time-us", int(time.Since(start)/time.Microsecond))
+	counter.IncKey("login-count")
+}
+
 func loginAppUser(ctx context.Context, checker *Checker, user *AppUser) error {
 	if user.Status.Online {
+		counter.IncKey("login-skipped")
 		return nil
 	}
 
+	defer observeLogin(time.Now())
 	err := checker.Play(ctx, &CheckAction{
 		Method:             "POST",
 		Path:               "/api/actions/login",
@@ -2397,7 +5622,15 @@ func loginAppUser(ctx context.Context, checker *Checker, user *AppUser) error {
 			"login_name": user.LoginName,
 			"password":   user.Password,
 		},
-		CheckFunc: checkJsonUserResponse(user),
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			if parameter.StrictSessionCookie {
+				err := checkSessionCookie(res)
+				if err != nil {
+					return err
+				}
+			}
+			return checkJsonUserResponse(user)(res, body)
+		},
 	})
 	if err != nil {
 		return err
@@ -2407,6 +5640,32 @@ func loginAppUser(ctx context.Context, checker *Checker, user *AppUser) error {
 	return nil
 }
 
+// Session cookies should not be readable from scripts and should be sent for all paths.
+// Other cookies, e.g. for analytics, are not checked.
+func checkSessionCookie(res *http.Response) error {
+	for _, cookie := range res.Cookies() {
+		if !isSessionCookieName(cookie.Name) {
+			continue
+		}
+		if !cookie.HttpOnly {
+			return fatalErrorf("セッションCookie(%s)にHttpOnly属性がありません", cookie.Name)
+		}
+		if cookie.Path != "" && cookie.Path != "/" {
+			return fatalErrorf("セッションCookie(%s)のPath属性が正しくありません", cookie.Name)
+		}
+	}
+	return nil
+}
+
+func isSessionCookieName(name string) bool {
+	for _, n := range parameter.SessionCookieNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
 func logoutAppUser(ctx context.Context, checker *Checker, user *AppUser) error {
 	if !user.Status.Online {
 		return nil
@@ -2434,11 +5693,11 @@ func popOrCreateEventSheet(ctx context.Context, state *State) (*EventSheet, func
 
 	// Create a new event if no sheet is available
 
-	ok := state.newEventMtx.TryLock()
-	if ok {
-		defer state.newEventMtx.Unlock()
-	} else {
-		log.Println("debug: Somebody else is trying to create a new event. Exit.")
+	select {
+	case state.newEventSem <- struct{}{}:
+		defer func() { <-state.newEventSem }()
+	default:
+		log.Println("debug: Too many others are trying to create a new event. Exit.")
 		// NOTE: We immediately return rather than waiting somebody else finishes to create a new event
 		// because probably the waiting strategy makes benchmarker work faster.
 		return nil, nil, nil
@@ -2462,6 +5721,7 @@ func popOrCreateEventSheet(ctx context.Context, state *State) (*EventSheet, func
 		ExpectedStatusCode: 200,
 		Description:        "管理者がイベントを作成できること",
 		PostJSON:           eventPostJSON(event),
+		StrictJSON:         true,
 		CheckFunc:          checkJsonFullEventCreateResponse(event),
 	})
 	if err != nil {
@@ -2473,17 +5733,76 @@ func popOrCreateEventSheet(ctx context.Context, state *State) (*EventSheet, func
 	return eventSheet, eventSheetPush, nil
 }
 
-func checkJsonReservationResponse(reserved *JsonReservation) func(res *http.Response, body *bytes.Buffer) error {
+// Creates a public event whose sheets are not pushed into eventSheets.
+// The caller can reserve, cancel and edit it freely without racing with load scenarios.
+func createDedicatedEvent(ctx context.Context, state *State, adminChecker *Checker, caller string) (*Event, error) {
+	return createDedicatedEventWithTitle(ctx, state, adminChecker, caller, "")
+}
+
+// Same with createDedicatedEvent, but the title is given instead of a random one unless it is empty
+func createDedicatedEventWithTitle(ctx context.Context, state *State, adminChecker *Checker, caller string, title string) (*Event, error) {
+	event, newEventPush := state.CreateNewEvent()
+	if title != "" {
+		event.Title = title
+	}
+
+	// Create as a private event so that its sheets go to privateEventSheets
+	event.PublicFg = false
+
+	err := adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               "/admin/api/events",
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを作成できること",
+		PostJSON:           eventPostJSON(event),
+		StrictJSON:         true,
+		CheckFunc:          checkJsonFullEventCreateResponse(event),
+	})
+	if err != nil {
+		return nil, err
+	}
+	newEventPush(caller)
+
+	event.PublicFg = true
+
+	err = adminChecker.Play(ctx, &CheckAction{
+		Method:             "POST",
+		Path:               fmt.Sprintf("/admin/api/events/%d/actions/edit", event.ID),
+		ExpectedStatusCode: 200,
+		Description:        "管理者がイベントを編集できること",
+		PostJSON:           eventEditJSON(event),
+		CheckFunc:          checkJsonFullEventResponse(event),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// price is checked only if the webapp returns it, and priced is set to whether it is returned unless nil.
+// The reference implementation does not, and reserveSheetAt checks the price by the event instead.
+func checkJsonReservationResponse(reserved *JsonReservation, price uint, priced *bool) func(res *http.Response, body *bytes.Buffer) error {
 	return func(res *http.Response, body *bytes.Buffer) error {
-		bytes := body.Bytes()
-		dec := json.NewDecoder(body)
-		resReserved := JsonReservation{}
-		err := dec.Decode(&resReserved)
+		resReserved := struct {
+			JsonReservation
+			Price *uint `json:"price"`
+		}{}
+		err := decodeJSON(body, &resReserved)
 		if err != nil {
-			return fatalErrorf("Jsonのデコードに失敗 %s %v", string(bytes), err)
+			return err
 		}
 		if resReserved.SheetRank != reserved.SheetRank {
-			return fatalErrorf("正しい予約情報を取得できません")
+			// e.g. the webapp silently substitutes an available rank
+			log.Printf("warn: requested rank=%s but reserved rank=%s (reservationID:%d)\n", reserved.SheetRank, resReserved.SheetRank, resReserved.ReservationID)
+			return fatalErrorf("予約したランクが異なります")
+		}
+		if priced != nil {
+			*priced = resReserved.Price != nil
+		}
+		if resReserved.Price != nil && *resReserved.Price != price {
+			log.Printf("info: miss match reservation price got=%d expected=%d (reservationID:%d)\n", *resReserved.Price, price, resReserved.ReservationID)
+			return fatalErrorf("予約した席の価格が正しくありません")
 		}
 		// Set reserved ID and Sheet Number from response
 		reserved.ReservationID = resReserved.ReservationID
@@ -2493,6 +5812,11 @@ func checkJsonReservationResponse(reserved *JsonReservation) func(res *http.Resp
 }
 
 func reserveSheet(ctx context.Context, state *State, checker *Checker, user *AppUser, eventSheet *EventSheet) (*Reservation, error) {
+	return reserveSheetAt(ctx, state, checker, user, eventSheet, fmt.Sprintf("/api/events/%d/actions/reserve", eventSheet.EventID))
+}
+
+// Same with reserveSheet, but requests to the given path
+func reserveSheetAt(ctx context.Context, state *State, checker *Checker, user *AppUser, eventSheet *EventSheet, path string) (*Reservation, error) {
 	eventID := eventSheet.EventID
 	rank := eventSheet.Rank
 
@@ -2500,15 +5824,16 @@ func reserveSheet(ctx context.Context, state *State, checker *Checker, user *App
 	reservation := &Reservation{ID: 0, EventID: eventID, UserID: user.ID, SheetRank: rank, Price: eventSheet.Price, SheetNum: 0}
 	logID := state.BeginReservation(user, reservation)
 
+	priced := false
 	err := checker.Play(ctx, &CheckAction{
 		Method:             "POST",
-		Path:               fmt.Sprintf("/api/events/%d/actions/reserve", eventID),
+		Path:               path,
 		ExpectedStatusCode: 202,
 		Description:        "席の予約ができること",
 		PostJSON: map[string]interface{}{
 			"sheet_rank": rank,
 		},
-		CheckFunc: checkJsonReservationResponse(reserved),
+		CheckFunc: checkJsonReservationResponse(reserved, eventSheet.Price, &priced),
 	})
 	if err != nil {
 		user.Status.PositiveTotalPrice += eventSheet.Price
@@ -2524,9 +5849,39 @@ func reserveSheet(ctx context.Context, state *State, checker *Checker, user *App
 	eventSheet.Num = reserved.SheetNum
 
 	log.Printf("debug: reserve userID:%d(total-price:%s) eventID:%d reservedID:%d(%s-%d) price:%d\n", user.ID, user.Status.TotalPriceString(), eventID, reserved.ReservationID, reserved.SheetRank, reserved.SheetNum, eventSheet.Price)
+
+	if !priced && parameter.ReservationPriceSampleRate > 0 && rand.Intn(parameter.ReservationPriceSampleRate) == 0 {
+		err = checkReservedSheetPrice(ctx, checker, reservation)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return reservation, nil
 }
 
+// Checks the price of the reserved sheet by the event, for the webapp which does not return it on reserve
+func checkReservedSheetPrice(ctx context.Context, checker *Checker, reservation *Reservation) error {
+	return checker.Play(ctx, &CheckAction{
+		Method:             "GET",
+		Path:               fmt.Sprintf("/api/events/%d", reservation.EventID),
+		ExpectedStatusCode: 200,
+		Description:        "予約した席の価格が正しいこと",
+		CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+			event := JsonEvent{}
+			err := decodeJSON(body, &event)
+			if err != nil {
+				return err
+			}
+			sheets, ok := event.Sheets[reservation.SheetRank]
+			if !ok || sheets.Price != reservation.Price {
+				log.Printf("info: miss match sheet price got=%d expected=%d (reservationID:%d)\n", sheets.Price, reservation.Price, reservation.ID)
+				return fatalErrorf("予約した席の価格が正しくありません")
+			}
+			return nil
+		},
+	})
+}
+
 func cancelSheet(ctx context.Context, state *State, checker *Checker, user *AppUser, eventSheet *EventSheet, reservation *Reservation) (already_locked bool, err error) {
 	// If somebody is canceling, nobody else should not cancel because, otherwise, double cancelation occurs.
 	// To achieve it, we use trylock instead of mutex.Lock()
@@ -2550,6 +5905,7 @@ func cancelSheet(ctx context.Context, state *State, checker *Checker, user *AppU
 		Path:               fmt.Sprintf("/api/events/%d/sheets/%s/%d/reservation", eventID, rank, sheetNum),
 		ExpectedStatusCode: 204,
 		Description:        "キャンセルができること",
+		CheckFunc:          checkEmptyBody,
 	})
 	if err != nil {
 		return false, err
@@ -0,0 +1,1661 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bench/counter"
+	"bench/parameter"
+)
+
+func TestLoadReservationChurn(t *testing.T) {
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+
+	// An initial reservation must be kept for CheckMyPage and CheckReport
+	initial := addTestInitialReservation(state, user, &Reservation{ID: 1, EventID: event.ID, UserID: user.ID, SheetRank: "S", SheetNum: 1, Price: 6000})
+	created := addTestReservation(state, user, &Reservation{ID: 2, EventID: event.ID, UserID: user.ID, SheetRank: "C", SheetNum: 1, Price: 1000})
+	sheets := len(state.eventSheets)
+
+	var deletes int32
+	mux := http.NewServeMux()
+	handleTestLogin(mux, user)
+	mux.HandleFunc("/api/events/1/sheets/C/1/reservation", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&deletes, 1)
+		w.WriteHeader(204)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(500)
+	})
+	defer startTestServer(mux)()
+
+	// Run concurrently so that the race detector sees both scenarios touching the reservation
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := LoadReservationChurn(context.Background(), state); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&deletes); n != 1 {
+		t.Fatalf("expected 1 cancel request, got %d", n)
+	}
+	if created.CancelCompletedAt.IsZero() {
+		t.Error("benchmarker-created reservation is not canceled")
+	}
+	if !initial.CancelRequestedAt.IsZero() {
+		t.Error("initial reservation is canceled")
+	}
+	if got := len(state.eventSheets); got != sheets+1 {
+		t.Errorf("freed sheet is not pushed back: eventSheets %d -> %d", sheets, got)
+	}
+	if user.Status.PositiveTotalPrice != 6000 || user.Status.NegativeTotalPrice != 6000 {
+		t.Errorf("unexpected total price %s", user.Status.TotalPriceString())
+	}
+}
+
+func TestCheckJsonFullUserResponseCanceled(t *testing.T) {
+	defer func(v bool) { parameter.MyPageListsCanceledReservations = v }(parameter.MyPageListsCanceledReservations)
+
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	now := time.Now().Unix()
+	addTestInitialReservation(state, user, &Reservation{ID: 1, EventID: event.ID, UserID: user.ID, SheetRank: "S", SheetNum: 1, Price: 6000, ReservedAt: now - 20})
+	addTestInitialReservation(state, user, &Reservation{ID: 2, EventID: event.ID, UserID: user.ID, SheetRank: "C", SheetNum: 1, Price: 1000, ReservedAt: now - 10, CanceledAt: now - 5})
+	timeBefore := time.Now()
+
+	fullUser := func(totalPrice uint, canceledAt uint, listCanceled bool) *bytes.Buffer {
+		jsonEvent := newTestJsonFullEvent(event.ID, event.Title, event.Price, true, false)
+		v := JsonFullUser{JsonUser: JsonUser{ID: user.ID, Nickname: user.Nickname}, TotalPrice: totalPrice, RecentEvents: []*JsonFullEvent{&jsonEvent}}
+		eventInReservation := &JsonEventInFullReservation{ID: event.ID, Title: event.Title, Public: true}
+		if listCanceled {
+			v.RecentReservations = append(v.RecentReservations, &JsonFullReservation{JsonReservation{2, "C", 1}, eventInReservation, 1000, uint(now - 10), canceledAt})
+		}
+		v.RecentReservations = append(v.RecentReservations, &JsonFullReservation{JsonReservation{1, "S", 1}, eventInReservation, 6000, uint(now - 20), 0})
+		body := &bytes.Buffer{}
+		json.NewEncoder(body).Encode(v)
+		return body
+	}
+	noop := func(*JsonFullUser) error { return nil }
+
+	cases := []struct {
+		name         string
+		listsHistory bool
+		body         *bytes.Buffer
+		ok           bool
+	}{
+		{"history", true, fullUser(6000, uint(now-5), true), true},
+		{"no history", false, fullUser(6000, 0, false), true},
+		{"canceled counted in total", true, fullUser(7000, uint(now-5), true), false},
+		{"canceled without canceled_at", true, fullUser(6000, 0, true), false},
+		{"canceled listed without history", false, fullUser(6000, uint(now-5), true), false},
+	}
+	for _, c := range cases {
+		parameter.MyPageListsCanceledReservations = c.listsHistory
+		err := checkJsonFullUserResponse(state, user, timeBefore, noop)(nil, c.body)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestCheckJsonFullUserResponseTotalPrice(t *testing.T) {
+	defer func(v bool) { parameter.MyPageListsCanceledReservations = v }(parameter.MyPageListsCanceledReservations)
+	parameter.MyPageListsCanceledReservations = true
+
+	state := newTestState()
+	user := addTestUser(state, 1)
+	e1 := addTestEvent(state, 1, 1000)
+	e2 := addTestEvent(state, 2, 2000)
+	now := time.Now().Unix()
+
+	// Active and canceled ones of both the initial dataset and the benchmarker, and a pending one
+	r1 := addTestInitialReservation(state, user, &Reservation{ID: 1, EventID: e1.ID, UserID: user.ID, SheetRank: "S", SheetNum: 1, Price: 6000, ReservedAt: now - 30})
+	r2 := addTestInitialReservation(state, user, &Reservation{ID: 2, EventID: e1.ID, UserID: user.ID, SheetRank: "C", SheetNum: 1, Price: 1000, ReservedAt: now - 25, CanceledAt: now - 20})
+	r3 := addTestReservation(state, user, &Reservation{ID: 3, EventID: e2.ID, UserID: user.ID, SheetRank: "A", SheetNum: 1, Price: 6000})
+	r4 := addTestReservation(state, user, &Reservation{ID: 4, EventID: e2.ID, UserID: user.ID, SheetRank: "B", SheetNum: 1, Price: 4000})
+	cancelTestReservation(state, user, r4)
+	state.BeginReservation(user, &Reservation{EventID: e2.ID, UserID: user.ID, SheetRank: "C", Price: 3000})
+	time.Sleep(time.Millisecond)
+	timeBefore := time.Now()
+
+	fullUser := func(totalPrice uint, reservations []*Reservation, events ...*Event) *bytes.Buffer {
+		v := JsonFullUser{JsonUser: JsonUser{ID: user.ID, Nickname: user.Nickname}, TotalPrice: totalPrice}
+		v.RecentReservations = []*JsonFullReservation{}
+		for _, r := range reservations {
+			e := state.FindEventByID(r.EventID)
+			var canceledAt uint
+			if !r.CancelCompletedAt.IsZero() {
+				canceledAt = uint(r.CancelCompletedAt.Unix())
+			}
+			v.RecentReservations = append(v.RecentReservations, &JsonFullReservation{
+				JsonReservation{r.ID, r.SheetRank, r.SheetNum},
+				&JsonEventInFullReservation{ID: e.ID, Title: e.Title, Public: true},
+				r.Price, uint(r.ReserveCompletedAt.Unix()), canceledAt,
+			})
+		}
+		v.RecentEvents = []*JsonFullEvent{}
+		for _, e := range events {
+			jsonEvent := newTestJsonFullEvent(e.ID, e.Title, e.Price, true, false)
+			v.RecentEvents = append(v.RecentEvents, &jsonEvent)
+		}
+		body := &bytes.Buffer{}
+		json.NewEncoder(body).Encode(v)
+		return body
+	}
+	noop := func(*JsonFullUser) error { return nil }
+
+	all := []*Reservation{r4, r3, r2, r1}
+	cases := []struct {
+		name string
+		body *bytes.Buffer
+		ok   bool
+	}{
+		{"settled", fullUser(12000, all, e2, e1), true},
+		{"pending reflected", fullUser(15000, all, e2, e1), true},
+		{"canceled counted in total", fullUser(16000, all, e2, e1), false},
+		{"active not reflected", fullUser(6000, []*Reservation{r4, r2, r1}, e2, e1), false},
+		{"active not counted in total", fullUser(6000, all, e2, e1), false},
+		{"event not listed", fullUser(12000, all, e1), false},
+	}
+	for _, c := range cases {
+		err := checkJsonFullUserResponse(state, user, timeBefore, noop)(nil, c.body)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+	}
+}
+
+// Counts events created by popOrCreateEventSheet in a fixed duration
+func countCreatedEvents(t *testing.T, concurrency int) int {
+	defer func(v int) { parameter.MaxConcurrentEventCreations = v }(parameter.MaxConcurrentEventCreations)
+	parameter.MaxConcurrentEventCreations = concurrency
+
+	state := newTestState()
+	var admins []*Administrator
+	for i := 1; i <= 8; i++ {
+		admins = append(admins, addTestAdmin(state, uint(i)))
+	}
+
+	mux := http.NewServeMux()
+	handleTestAdminLogin(mux, admins...)
+	created := handleTestCreateEvent(mux, 50*time.Millisecond)
+	defer startTestServer(mux)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				// Throw sheets away so that each goroutine keeps needing a new event
+				state.mtx.Lock()
+				state.eventSheets = nil
+				state.mtx.Unlock()
+
+				eventSheet, _, err := popOrCreateEventSheet(ctx, state)
+				if err != nil && ctx.Err() == nil {
+					t.Error(err)
+					return
+				}
+				if eventSheet == nil {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(state.GetEvents()); n > created() {
+		t.Errorf("%d events are registered but %d are created", n, created())
+	}
+	return created()
+}
+
+func TestPopOrCreateEventSheetConcurrentCreations(t *testing.T) {
+	serialized := countCreatedEvents(t, 1)
+	concurrent := countCreatedEvents(t, 4)
+	t.Logf("events created in 500ms: serialized=%d concurrent=%d", serialized, concurrent)
+
+	if concurrent < serialized*2 {
+		t.Errorf("concurrent event creations should be faster: serialized=%d concurrent=%d", serialized, concurrent)
+	}
+}
+
+func TestPopOrCreateEventSheetMultipleAdministrators(t *testing.T) {
+	defer func(v []*Administrator) { DataSet.Administrators = v }(DataSet.Administrators)
+	defer func(v int) { parameter.MaxAdministrators = v }(parameter.MaxAdministrators)
+	defer func(v bool) { parameter.AdministratorRoundRobin = v }(parameter.AdministratorRoundRobin)
+	defer func(v int) { parameter.MaxConcurrentEventCreations = v }(parameter.MaxConcurrentEventCreations)
+	parameter.MaxAdministrators = 4
+	parameter.AdministratorRoundRobin = true
+	parameter.MaxConcurrentEventCreations = 1
+
+	DataSet.Administrators = nil
+	for i := 1; i <= 6; i++ {
+		DataSet.Administrators = append(DataSet.Administrators, &Administrator{
+			ID:        uint(i),
+			Nickname:  fmt.Sprint("admin", i),
+			LoginName: fmt.Sprint("admin", i),
+			Password:  fmt.Sprint("admin", i),
+		})
+	}
+	state := newTestState()
+
+	var mtx sync.Mutex
+	loggedIn := map[string]int{}
+	var inFlight, maxInFlight int32
+	// Counts logins and concurrent creations in front of the mock APIs
+	creation := http.NewServeMux()
+	handleTestAdminLogin(creation, DataSet.Administrators...)
+	created := handleTestCreateEvent(creation, 20*time.Millisecond)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req struct {
+			LoginName string `json:"login_name"`
+		}
+		json.Unmarshal(body, &req)
+		mtx.Lock()
+		loggedIn[req.LoginName]++
+		mtx.Unlock()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		creation.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/admin/api/events", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		creation.ServeHTTP(w, r)
+		atomic.AddInt32(&inFlight, -1)
+	})
+	defer startTestServer(mux)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				// Throw sheets away so that each goroutine keeps needing a new event
+				state.mtx.Lock()
+				state.eventSheets = nil
+				state.mtx.Unlock()
+
+				eventSheet, _, err := popOrCreateEventSheet(ctx, state)
+				if err != nil && ctx.Err() == nil {
+					t.Error(err)
+					return
+				}
+				if eventSheet == nil {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxInFlight); max != 1 {
+		t.Errorf("expected event creations to be serialized, got %d at the same time", max)
+	}
+	if created() < 4 {
+		t.Fatalf("expected events to be created by all administrators, got %d events", created())
+	}
+	// Each of the first 4 administrators logs in once, and the others are never used
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, admin := range DataSet.Administrators {
+		expected := 0
+		if admin.ID <= 4 {
+			expected = 1
+		}
+		if n := loggedIn[admin.LoginName]; n != expected {
+			t.Errorf("administrator %d logged in %d times, expected %d", admin.ID, n, expected)
+		}
+	}
+}
+
+func TestReportChunkedResponse(t *testing.T) {
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	for i := uint(1); i <= 20; i++ {
+		addTestReservation(state, user, &Reservation{ID: i, EventID: event.ID, UserID: user.ID, SheetRank: "A", SheetNum: i, Price: 4000})
+	}
+	rows := testReportRows(state.GetReservations())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/api/reports/sales", func(w http.ResponseWriter, r *http.Request) {
+		serveTestReport(w, rows, r.URL.Query().Get("chunked") != "")
+	})
+	mux.HandleFunc("/admin/api/reports/events/1/sales", func(w http.ResponseWriter, r *http.Request) {
+		serveTestReport(w, rows, r.URL.Query().Get("chunked") != "")
+	})
+	defer startTestServer(mux)()
+
+	ctx := context.Background()
+	checker := NewChecker()
+	bodies := map[bool]string{}
+	for _, chunked := range []bool{false, true} {
+		query := ""
+		if chunked {
+			query = "?chunked=1"
+		}
+		timeBefore := time.Now()
+		reservations := state.GetCopiedReservations()
+
+		// Buffered by Play
+		err := checker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               "/admin/api/reports/events/1/sales" + query,
+			ExpectedStatusCode: 200,
+			CheckFunc: func(res *http.Response, body *bytes.Buffer) error {
+				if isChunked := res.ContentLength < 0; isChunked != chunked {
+					t.Errorf("chunked=%t but Content-Length=%d", chunked, res.ContentLength)
+				}
+				bodies[chunked] = body.String()
+				return checkEventReportResponse(state, event, timeBefore, reservations)(res, body)
+			},
+		})
+		if err != nil {
+			t.Errorf("chunked=%t: event report: %v", chunked, err)
+		}
+
+		// Streamed
+		err = checker.Play(ctx, &CheckAction{
+			Method:             "GET",
+			Path:               "/admin/api/reports/sales" + query,
+			ExpectedStatusCode: 200,
+			StreamCheckFunc:    checkReportResponse(state, timeBefore, reservations),
+		})
+		if err != nil {
+			t.Errorf("chunked=%t: sales report: %v", chunked, err)
+		}
+	}
+
+	if bodies[false] != bodies[true] {
+		t.Errorf("chunked body differs from Content-Length one:\n%s\n%s", bodies[false], bodies[true])
+	}
+}
+
+// Counts bytes read through it and closes started on the first read
+type startedReader struct {
+	r       io.Reader
+	n       int64
+	started chan struct{}
+}
+
+func (sr *startedReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if sr.n == 0 && n > 0 {
+		close(sr.started)
+	}
+	sr.n += int64(n)
+	return n, err
+}
+
+func TestReportStream100k(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds 100k reservations")
+	}
+	const numRows = 100000
+	state := newTestState()
+	user := addTestUser(state, 1)
+	for i := uint(1); i <= numRows/1000; i++ {
+		addTestEvent(state, i, 1000)
+	}
+	for i := 0; i < numRows; i++ {
+		sheet := DataSet.Sheets[i%len(DataSet.Sheets)]
+		addTestReservation(state, user, &Reservation{
+			ID:        uint(i + 1),
+			EventID:   uint(i/1000 + 1),
+			UserID:    user.ID,
+			SheetRank: sheet.Rank,
+			SheetNum:  sheet.Num,
+			Price:     1000 + GetSheetKindByRank(sheet.Rank).Price,
+		})
+	}
+	buf := &bytes.Buffer{}
+	csv.NewWriter(buf).WriteAll(testReportRows(state.GetReservations()))
+	report := buf.Bytes()
+
+	// The second half is sent only after the checker starts to read the first half,
+	// so the report cannot be buffered as a whole before it is checked
+	reader := &startedReader{started: make(chan struct{})}
+	var buffered int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/api/reports/sales", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		half := len(report) / 2
+		w.Write(report[:half])
+		w.(http.Flusher).Flush()
+		select {
+		case <-reader.started:
+		case <-time.After(5 * time.Second):
+			atomic.StoreInt32(&buffered, 1)
+		}
+		w.Write(report[half:])
+	})
+	defer startTestServer(mux)()
+
+	before := counter.GetKey("bytes-in")
+	timeBefore := time.Now()
+	err := NewChecker().Play(context.Background(), &CheckAction{
+		Method:             "GET",
+		Path:               "/admin/api/reports/sales",
+		ExpectedStatusCode: 200,
+		Timeout:            30 * time.Second,
+		StreamCheckFunc: func(res *http.Response, body io.Reader) error {
+			reader.r = body
+			return checkReportResponse(state, timeBefore, state.GetCopiedReservations())(res, reader)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&buffered) != 0 {
+		t.Error("expected the report to be read before it is completely sent")
+	}
+	if reader.n != int64(len(report)) {
+		t.Errorf("expected %d bytes to be read, got %d", len(report), reader.n)
+	}
+	if n := counter.GetKey("bytes-in") - before; n != int64(len(report)) {
+		t.Errorf("expected %d bytes to be counted, got %d", len(report), n)
+	}
+}
+
+func TestReportSoldAtBackwards(t *testing.T) {
+	defer func(v time.Duration) { parameter.ReportSoldAtBackwardsThreshold = v }(parameter.ReportSoldAtBackwardsThreshold)
+	defer log.SetOutput(log.Writer())
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+
+	for _, c := range []struct {
+		name      string
+		jump      time.Duration
+		threshold time.Duration
+		warned    bool
+	}{
+		{"large jump", time.Hour, time.Second, true},
+		{"within threshold", time.Second, 2 * time.Second, false},
+		{"disabled", time.Hour, 0, false},
+	} {
+		parameter.ReportSoldAtBackwardsThreshold = c.threshold
+		state := newTestState()
+		user := addTestUser(state, 1)
+		event := addTestEvent(state, 1, 1000)
+		for i := uint(1); i <= 3; i++ {
+			addTestReservation(state, user, &Reservation{ID: i, EventID: event.ID, UserID: user.ID, SheetRank: "A", SheetNum: i, Price: 4000})
+		}
+		// The reservation 2 is sold later than the reservation 3
+		state.reservations[2].ReserveCompletedAt = state.reservations[3].ReserveCompletedAt.Add(c.jump)
+		rows := testReportRows(state.GetReservations())
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/admin/api/reports/sales", func(w http.ResponseWriter, r *http.Request) {
+			serveTestReport(w, rows, true)
+		})
+		closeServer := startTestServer(mux)
+
+		before := counter.GetKey("report-sold-at-backwards")
+		logs.Reset()
+		err := NewChecker().Play(context.Background(), &CheckAction{
+			Method:             "GET",
+			Path:               "/admin/api/reports/sales",
+			ExpectedStatusCode: 200,
+			StreamCheckFunc:    checkReportResponse(state, time.Now(), state.GetCopiedReservations()),
+		})
+		closeServer()
+		if err != nil {
+			t.Errorf("%s: expected only a warning, got %v", c.name, err)
+		}
+		if warned := strings.Contains(logs.String(), "warn: sold_at of reservation_id=3"); warned != c.warned {
+			t.Errorf("%s: expected warned=%t, got %t", c.name, c.warned, warned)
+		}
+		expected := int64(0)
+		if c.warned {
+			expected = 1
+		}
+		if n := counter.GetKey("report-sold-at-backwards") - before; n != expected {
+			t.Errorf("%s: expected %d backwards counted, got %d", c.name, expected, n)
+		}
+	}
+}
+
+func TestReportStrictUTC(t *testing.T) {
+	defer func(v bool) { parameter.StrictReportUTC = v }(parameter.StrictReportUTC)
+	state := newTestState()
+
+	header := strings.Join(reportHeader, ",") + "\n"
+	utc := header + "1,1,S,1,6000,1,2018-08-17T04:55:30Z,2018-08-17T04:58:31Z\n"
+	jst := header + "1,1,S,1,6000,1,2018-08-17T13:55:30+09:00,\n"
+	jstCanceled := header + "1,1,S,1,6000,1,2018-08-17T04:55:30Z,2018-08-17T13:58:31+09:00\n"
+
+	for _, strict := range []bool{false, true} {
+		parameter.StrictReportUTC = strict
+		for _, c := range []struct {
+			name string
+			body string
+			ok   bool
+		}{
+			{"Z", utc, true},
+			{"+09:00 sold_at", jst, !strict},
+			{"+09:00 canceled_at", jstCanceled, !strict},
+		} {
+			records, err := parseTestReport(state, c.body)
+			if c.ok && err != nil {
+				t.Errorf("strict=%t %s: unexpected error %v", strict, c.name, err)
+			} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "UTC")) {
+				t.Errorf("strict=%t %s: expected an UTC error, got %v", strict, c.name, err)
+			}
+			if err == nil && !records[1].SoldAt.Equal(time.Date(2018, 8, 17, 4, 55, 30, 0, time.UTC)) {
+				t.Errorf("strict=%t %s: unexpected sold_at %s", strict, c.name, records[1].SoldAt)
+			}
+		}
+	}
+}
+
+func TestReportSheetNumOutOfRange(t *testing.T) {
+	state := newTestState()
+	header := strings.Join(reportHeader, ",") + "\n"
+
+	for _, c := range []struct {
+		row string
+		ok  bool
+	}{
+		{"2,1,S,50,6000,1,2018-08-17T04:55:30Z,", true},
+		{"2,1,S,51,6000,1,2018-08-17T04:55:30Z,", false},
+		{"2,1,C,0,1000,1,2018-08-17T04:55:30Z,", false},
+		{"2,1,C,500,1000,1,2018-08-17T04:55:30Z,", true},
+		{"2,1,X,1,1000,1,2018-08-17T04:55:30Z,", false},
+	} {
+		_, err := parseTestReport(state, header+"1,1,A,1,4000,1,2018-08-17T04:55:29Z,\n"+c.row+"\n")
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.row, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "シート番号が正しくありません (line:2)")) {
+			t.Errorf("%s: expected a sheet number error at line 2, got %v", c.row, err)
+		}
+	}
+}
+
+func TestReportCanceledBeforeSold(t *testing.T) {
+	state := newTestState()
+	header := strings.Join(reportHeader, ",") + "\n"
+	last := "2,1,A,1,4000,1,2018-08-17T04:55:31Z,\n"
+
+	for _, c := range []struct {
+		name       string
+		canceledAt string
+		ok         bool
+	}{
+		{"before", "2018-08-17T04:55:29Z", false},
+		{"equal", "2018-08-17T04:55:30Z", true},
+		{"after", "2018-08-17T04:55:31Z", true},
+	} {
+		_, err := parseTestReport(state, header+"1,1,S,1,6000,1,2018-08-17T04:55:30Z,"+c.canceledAt+"\n"+last)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "正しいレポートを取得できません")) {
+			t.Errorf("%s: expected a report error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestReportOrder(t *testing.T) {
+	defer func(v bool) { parameter.StrictReportOrder = v }(parameter.StrictReportOrder)
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	for i := uint(1); i <= 3; i++ {
+		addTestReservation(state, user, &Reservation{ID: i, EventID: event.ID, UserID: user.ID, SheetRank: "A", SheetNum: i, Price: 4000})
+	}
+	sorted := testReportRows(state.GetReservations())
+	unsorted := [][]string{sorted[0], sorted[1], sorted[3], sorted[2]}
+
+	for _, strict := range []bool{false, true} {
+		parameter.StrictReportOrder = strict
+		for _, eventID := range []uint{0, event.ID} {
+			if err := checkTestReport(state, sorted, eventID); err != nil {
+				t.Errorf("strict=%t eventID=%d: unexpected error %v", strict, eventID, err)
+			}
+			err := checkTestReport(state, unsorted, eventID)
+			if !strict && err != nil {
+				t.Errorf("strict=%t eventID=%d: unexpected error %v", strict, eventID, err)
+			} else if strict && (err == nil || !strings.Contains(err.Error(), "reservation_id順に並んでいません (line:3)")) {
+				t.Errorf("strict=%t eventID=%d: expected an order error at line 3, got %v", strict, eventID, err)
+			}
+		}
+	}
+}
+
+func TestReportDuplicatedRow(t *testing.T) {
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	for i := uint(1); i <= 3; i++ {
+		addTestReservation(state, user, &Reservation{ID: i, EventID: event.ID, UserID: user.ID, SheetRank: "A", SheetNum: i, Price: 4000})
+	}
+	rows := testReportRows(state.GetReservations())
+
+	// The count of rows is still within the bounds because of a pending reservation
+	state.BeginReservation(user, &Reservation{EventID: event.ID, UserID: user.ID, SheetRank: "A", Price: 4000})
+	duplicated := append(rows[:3:3], rows[2], rows[3])
+
+	for _, eventID := range []uint{0, event.ID} {
+		if err := checkTestReport(state, rows, eventID); err != nil {
+			t.Errorf("eventID=%d: unexpected error %v", eventID, err)
+		}
+		err := checkTestReport(state, duplicated, eventID)
+		if err == nil || !strings.Contains(err.Error(), "reservation_idが重複しています (line:3)") {
+			t.Errorf("eventID=%d: expected a duplication error at line 3, got %v", eventID, err)
+		}
+	}
+}
+
+func TestReportFabricatedRow(t *testing.T) {
+	defer func(v bool) { parameter.StrictReportReservations = v }(parameter.StrictReportReservations)
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	for i := uint(1); i <= 3; i++ {
+		addTestReservation(state, user, &Reservation{ID: i, EventID: event.ID, UserID: user.ID, SheetRank: "A", SheetNum: i, Price: 4000})
+	}
+	// The reservation 3 completes while the report is requested
+	reservationsBeforeRequest := state.GetCopiedReservations()
+	delete(reservationsBeforeRequest, 3)
+
+	// The row of the reservation 3 is replaced with the one the benchmarker never made
+	rows := testReportRows(state.GetReservations())
+	rows[3] = []string{"99", fmt.Sprint(event.ID), "A", "4", "4000", fmt.Sprint(user.ID), time.Now().UTC().Format(time.RFC3339), ""}
+	buf := &bytes.Buffer{}
+	csv.NewWriter(buf).WriteAll(rows)
+	check := func() error {
+		return checkReportResponse(state, time.Now(), reservationsBeforeRequest)(&http.Response{}, bytes.NewReader(buf.Bytes()))
+	}
+
+	parameter.StrictReportReservations = false
+	if err := check(); err != nil {
+		t.Errorf("strict=false: unexpected error %v", err)
+	}
+
+	parameter.StrictReportReservations = true
+	if err := check(); err == nil || !strings.Contains(err.Error(), "レポートに存在しない予約(予約id:99)") {
+		t.Errorf("strict=true: expected an unknown reservation error, got %v", err)
+	}
+
+	// Tolerated as the reservation of a reserve request not completed yet
+	state.BeginReservation(user, &Reservation{EventID: event.ID, UserID: user.ID, SheetRank: "A", Price: 4000})
+	if err := check(); err != nil {
+		t.Errorf("strict=true with a pending reservation: unexpected error %v", err)
+	}
+}
+
+func TestReportExtraField(t *testing.T) {
+	defer func(v bool) { parameter.ReportColumnsByName = v }(parameter.ReportColumnsByName)
+	state := newTestState()
+	header := strings.Join(reportHeader, ",") + "\n"
+	first := "1,1,S,1,6000,1,2018-08-17T04:55:30Z,\n"
+	extra := "2,1,A,1,4000,1,2018-08-17T04:55:31Z,,extra\n"
+
+	for _, byName := range []bool{false, true} {
+		parameter.ReportColumnsByName = byName
+		for _, body := range []string{header + extra + first, header + first + extra} {
+			_, err := parseTestReport(state, body)
+			if err == nil || !strings.Contains(err.Error(), "正しいCSVレポートを取得できません") {
+				t.Errorf("byName=%t %q: expected a CSV error, got %v", byName, body, err)
+			}
+		}
+	}
+}
+
+func TestReportTruncated(t *testing.T) {
+	state := newTestState()
+	header := strings.Join(reportHeader, ",") + "\n"
+	first := "1,1,S,1,6000,1,2018-08-17T04:55:30Z,\n"
+	const truncated = "レポートが途中で切れています"
+
+	for _, c := range []struct {
+		body      string
+		truncated bool
+	}{
+		{header + first + "2,1,A,1,40", true},
+		{header + first + "2,1,A,1,4000,1,2018-08-17T04:5", true},
+		{header + first + "2,1,A,1,4000,1,2018-08-17T04:55:31Z,2018-08", true},
+		{header + "1,1,S,1,60\n" + first, false},
+		{header + "1,1,S,1,6000,1,2018-08-17T04:55:30Z,,\n" + first, false},
+	} {
+		_, err := parseTestReport(state, c.body)
+		if err == nil {
+			t.Errorf("%q: expected an error", c.body)
+		} else if strings.Contains(err.Error(), truncated) != c.truncated {
+			t.Errorf("%q: truncated=%t, got %v", c.body, c.truncated, err)
+		}
+	}
+
+	// The server dies in the middle of a streamed report
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/api/reports/sales", func(w http.ResponseWriter, r *http.Request) {
+		body := header + first + "2,1,A,1,4000,1,"
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)+100))
+		w.Write([]byte(body))
+	})
+	defer startTestServer(mux)()
+
+	err := NewChecker().Play(context.Background(), &CheckAction{
+		Method:             "GET",
+		Path:               "/admin/api/reports/sales",
+		ExpectedStatusCode: 200,
+		StreamCheckFunc:    checkReportResponse(state, time.Now(), nil),
+	})
+	if err == nil || !strings.Contains(err.Error(), truncated) {
+		t.Errorf("expected a truncated error, got %v", err)
+	}
+}
+
+// Returns the JSON of the event whose first sheets of each rank are reserved so that remains[rank] sheets are left
+func testEventJSON(event *Event, remains map[string]uint) *bytes.Buffer {
+	e := newTestJsonFullEvent(event.ID, event.Title, event.Price, event.PublicFg, event.ClosedFg).JsonEvent
+	e.Remains = 0
+	for _, sheetKind := range DataSet.SheetKinds {
+		sheets := e.Sheets[sheetKind.Rank]
+		if r, ok := remains[sheetKind.Rank]; ok {
+			sheets.Remains = r
+		}
+		for num := uint(1); num <= sheets.Total; num++ {
+			sheets.Details = append(sheets.Details, JsonSheetDetail{Num: num, Reserved: num <= sheets.Total-sheets.Remains})
+		}
+		e.Sheets[sheetKind.Rank] = sheets
+		e.Remains += sheets.Remains
+	}
+	body := &bytes.Buffer{}
+	json.NewEncoder(body).Encode(e)
+	return body
+}
+
+func TestCheckJsonEventResponseRemains(t *testing.T) {
+	state := newTestState()
+	user := addTestUser(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	addTestReservation(state, user, &Reservation{ID: 1, EventID: event.ID, UserID: user.ID, SheetRank: "S", SheetNum: 1, Price: 6000})
+
+	check := checkJsonEventResponse(state, event, nil)
+	// A reservation of S is in-flight between the request and the response
+	state.BeginReservation(user, &Reservation{EventID: event.ID, UserID: user.ID, SheetRank: "S", Price: 6000})
+
+	for _, c := range []struct {
+		remains uint
+		ok      bool
+	}{
+		{50, false}, // the completed reservation is not reflected
+		{49, true},
+		{48, true}, // the in-flight reservation is reflected
+		{47, false},
+	} {
+		err := check(nil, testEventJSON(event, map[string]uint{"S": c.remains}))
+		if c.ok && err != nil {
+			t.Errorf("remains=%d: unexpected error %v", c.remains, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "S席の残座席数が正しくありません")) {
+			t.Errorf("remains=%d: expected a remains error, got %v", c.remains, err)
+		}
+	}
+}
+
+func TestCheckEventListMissedEvents(t *testing.T) {
+	state := newTestState()
+	old1 := addTestEvent(state, 1, 1000)
+	old2 := addTestEvent(state, 2, 1000)
+	latest := addTestEvent(state, 3, 1000)
+	addTestEvent(state, 4, 1000) // created just now
+	for _, e := range []*Event{old1, old2, latest} {
+		e.CreatedAt = time.Now().Add(-2 * parameter.AllowableDelay)
+	}
+
+	toJson := func(events ...*Event) []JsonEvent {
+		var jsonEvents []JsonEvent
+		for _, e := range events {
+			jsonEvents = append(jsonEvents, newTestJsonFullEvent(e.ID, e.Title, e.Price, e.PublicFg, e.ClosedFg).JsonEvent)
+		}
+		return jsonEvents
+	}
+
+	timeBefore := time.Now().Add(-1 * parameter.AllowableDelay)
+	eventsBeforeRequest := FilterEventsToAllowDelay(state.GetCopiedEvents(), timeBefore)
+	eventsAfterResponse := state.GetEvents()
+
+	// The event created within the allowable delay may be missed
+	if err := checkEventList(state, eventsBeforeRequest, toJson(old1, old2, latest), eventsAfterResponse); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Two older events are missed
+	err := checkEventList(state, eventsBeforeRequest, toJson(latest), eventsAfterResponse)
+	if !IsFatal(err) {
+		t.Errorf("expected a fatal error, got %v", err)
+	}
+	err = checkEventList(state, eventsBeforeRequest, toJson(old2, latest), eventsAfterResponse)
+	if !IsFatal(err) {
+		t.Errorf("expected a fatal error when one of them is missed, got %v", err)
+	}
+}
+
+func TestCheckReserveOversizedRank(t *testing.T) {
+	defer func(v int) { parameter.OversizedRankLength = v }(parameter.OversizedRankLength)
+	parameter.OversizedRankLength = 1024
+
+	for _, c := range []struct {
+		name    string
+		records bool
+		ok      bool
+	}{
+		{"rejected", false, true},
+		{"recorded despite the error", true, false},
+	} {
+		state := newTestState()
+		user := addTestUser(state, 1)
+		admin := addTestAdmin(state, 1)
+
+		var mtx sync.Mutex
+		remains := map[string]uint{}
+		mux := http.NewServeMux()
+		handleTestLogin(mux, user)
+		handleTestAdminLogin(mux, admin)
+		handleTestCreateEvent(mux, 0)
+		handleTestEditEvent(mux, state)
+		mux.HandleFunc("/api/events/", func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			event := state.FindEventByID(1)
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == "POST" {
+				if c.records {
+					// Truncates the rank to a valid one and reserves it
+					remains["S"] = 49
+				}
+				w.WriteHeader(400)
+				json.NewEncoder(w).Encode(JsonError{Error: "invalid_rank"})
+				return
+			}
+			w.Write(testEventJSON(event, remains).Bytes())
+		})
+		closeServer := startTestServer(mux)
+
+		err := CheckReserveOversizedRank(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestAssetPoolConcurrency(t *testing.T) {
+	defer func(v int) { parameter.AssetConcurrency = v }(parameter.AssetConcurrency)
+	parameter.AssetConcurrency = 4
+	// Start a pool of the size for this test
+	StopAssetPool()
+	assetPoolOnce = sync.Once{}
+	defer StopAssetPool()
+
+	var inFlight, maxInFlight, served int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&served, 1)
+	})
+	defer startTestServer(mux)()
+
+	var paths []string
+	for i := 0; i < 8; i++ {
+		paths = append(paths, fmt.Sprintf("/asset/%d", i))
+	}
+	dropped := func() int64 { return counter.SumPrefix("asset|dropped|") }
+	droppedBefore := dropped()
+
+	for i := 0; i < 8; i++ {
+		goLoadStaticFiles(context.Background(), NewChecker(), paths...)
+	}
+	submitted := int64(len(paths) * 8)
+	deadline := time.Now().Add(5 * time.Second)
+	for int64(atomic.LoadInt32(&served))+dropped()-droppedBefore < submitted && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := int64(atomic.LoadInt32(&served)) + dropped() - droppedBefore; n != submitted {
+		t.Errorf("expected %d assets to be served or dropped, got %d", submitted, n)
+	}
+	if max := atomic.LoadInt32(&maxInFlight); max > 4 || max < 2 {
+		t.Errorf("expected up to 4 concurrent asset requests, got %d", max)
+	}
+
+	// Jobs of a canceled context are skipped
+	atomic.StoreInt32(&served, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	goLoadStaticFiles(ctx, NewChecker(), paths...)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&served); n != 0 {
+		t.Errorf("expected assets of the canceled context to be skipped, but %d are served", n)
+	}
+}
+
+func TestLoginSessionCookie(t *testing.T) {
+	defer func(v bool) { parameter.StrictSessionCookie = v }(parameter.StrictSessionCookie)
+	state := newTestState()
+	user := addTestUser(state, 1)
+
+	var cookies []*http.Cookie
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+		for _, cookie := range cookies {
+			http.SetCookie(w, cookie)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(JsonUser{ID: user.ID, Nickname: user.Nickname})
+	})
+	defer startTestServer(mux)()
+
+	hardened := &http.Cookie{Name: "torb_session", Value: "x", Path: "/", HttpOnly: true}
+	for _, c := range []struct {
+		name    string
+		strict  bool
+		cookies []*http.Cookie
+		ok      bool
+	}{
+		{"hardened", true, []*http.Cookie{hardened}, true},
+		{"without HttpOnly", true, []*http.Cookie{{Name: "torb_session", Value: "x", Path: "/"}}, false},
+		{"without HttpOnly named session", true, []*http.Cookie{{Name: "session", Value: "x", Path: "/"}}, false},
+		{"without HttpOnly not strictly", false, []*http.Cookie{{Name: "torb_session", Value: "x", Path: "/"}}, true},
+		{"narrow path", true, []*http.Cookie{{Name: "torb_session", Value: "x", Path: "/api", HttpOnly: true}}, false},
+		{"other cookie without HttpOnly", true, []*http.Cookie{hardened, {Name: "_ga", Value: "x", Path: "/blog"}}, true},
+	} {
+		parameter.StrictSessionCookie = c.strict
+		cookies = c.cookies
+		user.Status.Online = false
+		err := loginAppUser(context.Background(), NewChecker(), user)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsCheckerFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckConcurrentReserve(t *testing.T) {
+	defer func(users, rounds int) {
+		parameter.ConcurrentReserveUsers = users
+		parameter.ConcurrentReserveRounds = rounds
+	}(parameter.ConcurrentReserveUsers, parameter.ConcurrentReserveRounds)
+	parameter.ConcurrentReserveUsers = 5
+	parameter.ConcurrentReserveRounds = 3
+
+	for _, c := range []struct {
+		name      string
+		seats     uint // sold out after it
+		duplicate bool // hands out the first seat twice
+		ok        bool
+	}{
+		{"distinct seats", 50, false, true},
+		{"sold out", 4, false, true},
+		{"duplicated seat", 50, true, false},
+	} {
+		state := newTestState()
+		admin := addTestAdmin(state, 1)
+		var users []*AppUser
+		for i := uint(1); i <= 5; i++ {
+			users = append(users, addTestUser(state, i))
+		}
+
+		var mtx sync.Mutex
+		var reserved uint
+		mux := http.NewServeMux()
+		handleTestLogin(mux, users...)
+		handleTestAdminLogin(mux, admin)
+		handleTestCreateEvent(mux, 0)
+		handleTestEditEvent(mux, state)
+		mux.HandleFunc("/api/events/1/actions/reserve", func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				SheetRank string `json:"sheet_rank"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			// Widen the window of races
+			time.Sleep(time.Millisecond)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			if reserved >= c.seats {
+				w.WriteHeader(409)
+				json.NewEncoder(w).Encode(JsonError{Error: "sold_out"})
+				return
+			}
+			reserved++
+			num := reserved
+			if c.duplicate && num == 2 {
+				num = 1
+			}
+			w.WriteHeader(202)
+			json.NewEncoder(w).Encode(JsonReservation{ReservationID: reserved, SheetRank: req.SheetRank, SheetNum: num})
+		})
+		closeServer := startTestServer(mux)
+
+		err := CheckConcurrentReserve(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "重複して予約されています")) {
+			t.Errorf("%s: expected a duplication error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckPrivateEventHidden(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		listed    bool
+		fetchable bool
+		ok        bool
+	}{
+		{"hidden", false, false, true},
+		{"listed", true, false, false},
+		{"fetchable", false, true, false},
+	} {
+		state := newTestState()
+		admin := addTestAdmin(state, 1)
+
+		mux := http.NewServeMux()
+		handleTestAdminLogin(mux, admin)
+		createdEvents := handleTestCreateEvent(mux, 0)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			events := []JsonEvent{}
+			if c.listed {
+				events = append(events, newTestJsonFullEvent(uint(createdEvents()), "leaked", 1000, false, false).JsonEvent)
+			}
+			b, _ := json.Marshal(events)
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><body><div id="app-wrapper" data-events="%s" data-login-user="null"></div></body></html>`, html.EscapeString(string(b)))
+		})
+		mux.HandleFunc("/api/events/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if c.fetchable {
+				json.NewEncoder(w).Encode(newTestJsonFullEvent(uint(createdEvents()), "leaked", 1000, false, false).JsonEvent)
+				return
+			}
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(JsonError{Error: "not_found"})
+		})
+		closeServer := startTestServer(mux)
+
+		err := CheckPrivateEventHidden(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if c.listed && (err == nil || !strings.Contains(err.Error(), "非公開のイベント(id:1)がトップページに表示されています")) {
+			t.Errorf("%s: expected a leaked event error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckSessionInvalidation(t *testing.T) {
+	defer func(v bool) { parameter.LogoutInvalidatesSession = v }(parameter.LogoutInvalidatesSession)
+	parameter.LogoutInvalidatesSession = true
+
+	for _, c := range []struct {
+		name          string
+		ignoresLogout bool
+		ok            bool
+	}{
+		{"destroys session", false, true},
+		{"ignores logout", true, false},
+	} {
+		state := newTestState()
+		user := addTestUser(state, 1)
+
+		var mtx sync.Mutex
+		sessions := map[string]bool{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+			id := RandomAlphabetString(16)
+			mtx.Lock()
+			sessions[id] = true
+			mtx.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "torb_session", Value: id, Path: "/", HttpOnly: true})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JsonUser{ID: user.ID, Nickname: user.Nickname})
+		})
+		mux.HandleFunc("/api/actions/logout", func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("torb_session"); err == nil && !c.ignoresLogout {
+				mtx.Lock()
+				delete(sessions, cookie.Value)
+				mtx.Unlock()
+			}
+			http.SetCookie(w, &http.Cookie{Name: "torb_session", Path: "/", MaxAge: -1})
+			w.WriteHeader(204)
+		})
+		mux.HandleFunc(fmt.Sprintf("/api/users/%d", user.ID), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			cookie, err := r.Cookie("torb_session")
+			mtx.Lock()
+			loggedIn := err == nil && sessions[cookie.Value]
+			mtx.Unlock()
+			if !loggedIn {
+				w.WriteHeader(401)
+				json.NewEncoder(w).Encode(JsonError{Error: "login_required"})
+				return
+			}
+			json.NewEncoder(w).Encode(JsonUser{ID: user.ID, Nickname: user.Nickname})
+		})
+		closeServer := startTestServer(mux)
+
+		err := CheckSessionInvalidation(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected the session replayed after logout to be detected", c.name)
+		}
+		if user.Status.Online {
+			t.Errorf("%s: expected the user to be logged out", c.name)
+		}
+	}
+}
+
+func TestCheckReportMatchesMyPage(t *testing.T) {
+	state := newTestState()
+	user := addTestUser(state, 1)
+	// The other user is not popped by the scenario
+	other := &AppUser{ID: 2, Nickname: "other", LoginName: "other", Password: "other"}
+	admin := addTestAdmin(state, 1)
+	event := addTestEvent(state, 1, 1000)
+	addTestReservation(state, user, &Reservation{ID: 1, EventID: event.ID, UserID: user.ID, SheetRank: "S", SheetNum: 1, Price: 6000})
+	addTestReservation(state, user, &Reservation{ID: 2, EventID: event.ID, UserID: user.ID, SheetRank: "A", SheetNum: 1, Price: 4000})
+	canceled := addTestReservation(state, user, &Reservation{ID: 3, EventID: event.ID, UserID: user.ID, SheetRank: "B", SheetNum: 1, Price: 2000})
+	cancelTestReservation(state, user, canceled)
+	addTestReservation(state, other, &Reservation{ID: 4, EventID: event.ID, UserID: other.ID, SheetRank: "C", SheetNum: 1, Price: 1000})
+	rows := testReportRows(state.GetReservations())
+
+	for _, c := range []struct {
+		name       string
+		totalPrice uint
+		ok         bool
+	}{
+		{"consistent", 10000, true},
+		{"canceled counted", 12000, false},
+		{"missing reservation", 6000, false},
+	} {
+		mux := http.NewServeMux()
+		handleTestLogin(mux, user)
+		handleTestAdminLogin(mux, admin)
+		mux.HandleFunc(fmt.Sprintf("/api/users/%d", user.ID), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JsonFullUser{JsonUser: JsonUser{ID: user.ID, Nickname: user.Nickname}, TotalPrice: c.totalPrice})
+		})
+		mux.HandleFunc("/admin/api/reports/sales", func(w http.ResponseWriter, r *http.Request) {
+			serveTestReport(w, rows, false)
+		})
+		closeServer := startTestServer(mux)
+
+		user.Status.Online = false
+		err := CheckReportMatchesMyPage(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "マイページの合計金額がレポートと一致しません")) {
+			t.Errorf("%s: expected an inconsistency error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckSingleUserReserveBurst(t *testing.T) {
+	total := int(DataSet.SheetKindMap["S"].Total)
+	for _, c := range []struct {
+		name         string
+		soldOutAfter int
+		ok           bool
+	}{
+		{"sold out after the rank", total, true},
+		{"never sold out", -1, false},
+		{"sold out too early", total - 1, false},
+	} {
+		state := newTestState()
+		user := addTestUser(state, 1)
+		admin := addTestAdmin(state, 1)
+
+		mux := http.NewServeMux()
+		handleTestLogin(mux, user)
+		handleTestAdminLogin(mux, admin)
+		handleTestCreateEvent(mux, 0)
+		handleTestEditEvent(mux, state)
+		reserved := handleTestReserve(mux, state, c.soldOutAfter)
+		closeServer := startTestServer(mux)
+
+		err := CheckSingleUserReserveBurst(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if c.ok && reserved() != total {
+			t.Errorf("%s: expected %d reservations, got %d", c.name, total, reserved())
+		}
+	}
+}
+
+func TestReserveSheetOrSoldOutTotalPrice(t *testing.T) {
+	for _, c := range []struct {
+		name          string
+		status        int
+		soldOut       bool
+		positivePrice uint
+	}{
+		// Sold out is never reserved, so the total price is settled
+		{"sold out", 409, true, 6000},
+		// The reservation may have been made, so the upper bound is widened
+		{"error", 500, false, 18000},
+	} {
+		state := newTestState()
+		user := addTestUser(state, 1)
+		event := addTestEvent(state, 1, 1000)
+		addTestReservation(state, user, &Reservation{ID: 1, EventID: event.ID, UserID: user.ID, SheetRank: "S", SheetNum: 1, Price: 6000})
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/events/1/actions/reserve", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(c.status)
+			json.NewEncoder(w).Encode(JsonError{Error: "sold_out"})
+		})
+		closeServer := startTestServer(mux)
+
+		_, soldOut, err := reserveSheetOrSoldOut(context.Background(), state, NewChecker(), user, &EventSheet{event.ID, "S", NonReservedNum, 6000})
+		closeServer()
+		if soldOut != c.soldOut || (err == nil) != c.soldOut {
+			t.Errorf("%s: unexpected result soldOut=%v err=%v", c.name, soldOut, err)
+		}
+		if user.Status.PositiveTotalPrice != c.positivePrice || user.Status.NegativeTotalPrice != 6000 {
+			t.Errorf("%s: unexpected total price %s", c.name, user.Status.TotalPriceString())
+		}
+	}
+}
+
+func TestCheckReserveSoldOut(t *testing.T) {
+	total := int(DataSet.SheetKindMap["S"].Total)
+	for _, c := range []struct {
+		name         string
+		soldOutAfter int
+		ok           bool
+	}{
+		{"sold out after the rank", total, true},
+		{"never sold out", -1, false},
+		{"sold out too early", total - 1, false},
+	} {
+		state := newTestState()
+		user := addTestUser(state, 1)
+		admin := addTestAdmin(state, 1)
+
+		mux := http.NewServeMux()
+		handleTestLogin(mux, user)
+		handleTestAdminLogin(mux, admin)
+		createdEvents := handleTestCreateEvent(mux, 0)
+		handleTestEditEvent(mux, state)
+		handleTestReserve(mux, state, c.soldOutAfter)
+		closeServer := startTestServer(mux)
+
+		err := CheckReserveSoldOut(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if !c.ok {
+			continue
+		}
+		if createdEvents() != 1 {
+			t.Fatalf("%s: expected 1 event, got %d", c.name, createdEvents())
+		}
+
+		reservations := state.GetCopiedReservationsInEventID(1)
+		if len(reservations) != total {
+			t.Errorf("%s: expected %d reservations in state, got %d", c.name, total, len(reservations))
+		}
+		for _, r := range reservations {
+			if r.ReserveCompletedAt.IsZero() || r.SheetRank != "S" || r.UserID != user.ID {
+				t.Errorf("%s: reservation %d is not committed %+v", c.name, r.ID, r)
+			}
+		}
+	}
+}
+
+func TestCheckSessionAcrossRestart(t *testing.T) {
+	defer func(v bool) { parameter.SessionSurvivesRestart = v }(parameter.SessionSurvivesRestart)
+
+	for _, c := range []struct {
+		name         string
+		survives     bool // parameter.SessionSurvivesRestart
+		keepSessions bool // the server
+		ok           bool
+	}{
+		{"sessions survive", true, true, true},
+		{"sessions lost unexpectedly", true, false, false},
+		{"sessions invalidated", false, false, true},
+		{"sessions survive unexpectedly", false, true, false},
+	} {
+		parameter.SessionSurvivesRestart = c.survives
+		state := newTestState()
+		user := addTestUser(state, 1)
+
+		// Sessions are named after the generation of the server
+		var mtx sync.Mutex
+		generation := 0
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/actions/login", func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "torb_session", Value: fmt.Sprint(generation), Path: "/"})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JsonUser{ID: user.ID, Nickname: user.Nickname})
+		})
+		mux.HandleFunc("/api/users/", func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			cookie, err := r.Cookie("torb_session")
+			if err != nil || (!c.keepSessions && cookie.Value != fmt.Sprint(generation)) {
+				w.WriteHeader(401)
+				json.NewEncoder(w).Encode(JsonError{Error: "login_required"})
+				return
+			}
+			json.NewEncoder(w).Encode(JsonFullUser{JsonUser: JsonUser{ID: user.ID, Nickname: user.Nickname}})
+		})
+		closeServer := startTestServer(mux)
+
+		// Captures a session, and nothing happens until the restart
+		for i := 0; i < 2; i++ {
+			if err := CheckSessionAcrossRestart(context.Background(), state); err != nil {
+				t.Fatalf("%s: unexpected error before restart %v", c.name, err)
+			}
+		}
+
+		mtx.Lock()
+		generation++
+		mtx.Unlock()
+		state.NotifyServerRestarted()
+
+		err := CheckSessionAcrossRestart(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestCheckCancelNotReservedEachRank(t *testing.T) {
+	cases := []string{""} // the rank the server answers wrongly for
+	for _, sheetKind := range DataSet.SheetKinds {
+		cases = append(cases, sheetKind.Rank)
+	}
+	for _, badRank := range cases {
+		state := newTestState()
+		user := addTestUser(state, 1)
+		admin := addTestAdmin(state, 1)
+
+		var mtx sync.Mutex
+		canceled := map[string]int{}
+		mux := http.NewServeMux()
+		handleTestLogin(mux, user)
+		handleTestAdminLogin(mux, admin)
+		handleTestCreateEvent(mux, 0)
+		handleTestEditEvent(mux, state)
+		mux.HandleFunc("/api/events/", func(w http.ResponseWriter, r *http.Request) {
+			var eventID, num uint
+			var rank string
+			fmt.Sscanf(r.URL.Path, "/api/events/%d/sheets/%1s/%d/reservation", &eventID, &rank, &num)
+			mtx.Lock()
+			canceled[rank]++
+			mtx.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			if rank == badRank {
+				json.NewEncoder(w).Encode(JsonError{Error: "invalid_sheet"})
+				return
+			}
+			json.NewEncoder(w).Encode(JsonError{Error: "not_reserved"})
+		})
+		closeServer := startTestServer(mux)
+
+		err := CheckCancelNotReservedEachRank(context.Background(), state)
+		closeServer()
+		if badRank == "" {
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			for _, sheetKind := range DataSet.SheetKinds {
+				if canceled[sheetKind.Rank] == 0 {
+					t.Errorf("rank %s is not checked", sheetKind.Rank)
+				}
+			}
+		} else if !IsFatal(err) {
+			t.Errorf("rank %s: expected a fatal error, got %v", badRank, err)
+		}
+	}
+}
+
+func TestCheckEventSheetTotals(t *testing.T) {
+	modified := func(f func(e *JsonFullEvent)) JsonEvent {
+		e := newTestJsonFullEvent(1, "test", 1000, true, false)
+		f(&e)
+		return e.JsonEvent
+	}
+	withSheet := func(e *JsonFullEvent, rank string, f func(s *JsonSheet)) {
+		s := e.Sheets[rank]
+		f(&s)
+		e.Sheets[rank] = s
+	}
+
+	cases := []struct {
+		name  string
+		event JsonEvent
+		fresh bool
+		ok    bool
+	}{
+		{"correct", modified(func(e *JsonFullEvent) {}), true, true},
+		{"wrong total", modified(func(e *JsonFullEvent) { e.Total++ }), false, false},
+		{"rank totals not summing up", modified(func(e *JsonFullEvent) {
+			withSheet(e, "S", func(s *JsonSheet) { s.Total--; s.Remains-- })
+			e.Remains--
+		}), false, false},
+		{"rank missing", modified(func(e *JsonFullEvent) { delete(e.Sheets, "C") }), false, false},
+		{"rank remains not summing up", modified(func(e *JsonFullEvent) { e.Remains-- }), false, false},
+		{"reserved", modified(func(e *JsonFullEvent) {
+			withSheet(e, "A", func(s *JsonSheet) { s.Remains-- })
+			e.Remains--
+		}), false, true},
+		{"reserved but fresh", modified(func(e *JsonFullEvent) {
+			withSheet(e, "A", func(s *JsonSheet) { s.Remains-- })
+			e.Remains--
+		}), true, false},
+	}
+	for _, c := range cases {
+		err := checkEventSheetTotals(c.event, c.fresh)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckJsonReservationResponsePrice(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		ok     bool
+		priced bool
+	}{
+		{"correct price", `{"id":1,"sheet_rank":"S","sheet_num":1,"price":6000}`, true, true},
+		{"wrong price", `{"reservation_id":1,"sheet_rank":"S","sheet_num":1,"price":1}`, false, true},
+		{"no price", `{"id":1,"sheet_rank":"S","sheet_num":1}`, true, false},
+	}
+	for _, c := range cases {
+		reserved := &JsonReservation{SheetRank: "S"}
+		priced := !c.priced
+		err := checkJsonReservationResponse(reserved, 6000, &priced)(nil, bytes.NewBufferString(c.body))
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+		if priced != c.priced {
+			t.Errorf("%s: expected priced=%v", c.name, c.priced)
+		}
+		if c.ok && (reserved.ReservationID != 1 || reserved.SheetNum != 1) {
+			t.Errorf("%s: unexpected reservation %+v", c.name, reserved)
+		}
+	}
+}
+
+func TestReserveSheetPriceByEvent(t *testing.T) {
+	defer func(v int) { parameter.ReservationPriceSampleRate = v }(parameter.ReservationPriceSampleRate)
+	parameter.ReservationPriceSampleRate = 1
+
+	for _, c := range []struct {
+		name       string
+		sheetPrice uint
+		ok         bool
+	}{
+		{"correct price", 6000, true},
+		{"wrong price", 1, false},
+	} {
+		state := newTestState()
+		user := addTestUser(state, 1)
+		event := addTestEvent(state, 1, 1000)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/events/1/actions/reserve", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(202)
+			json.NewEncoder(w).Encode(JsonReservation{ReservationID: 1, SheetRank: "S", SheetNum: 1})
+		})
+		mux.HandleFunc("/api/events/1", func(w http.ResponseWriter, r *http.Request) {
+			e := newTestJsonFullEvent(event.ID, event.Title, event.Price, true, false)
+			s := e.Sheets["S"]
+			s.Price = c.sheetPrice
+			e.Sheets["S"] = s
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(e.JsonEvent)
+		})
+		closeServer := startTestServer(mux)
+
+		_, err := reserveSheet(context.Background(), state, NewChecker(), user, &EventSheet{event.ID, "S", NonReservedNum, 6000})
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckUnpublishEvent(t *testing.T) {
+	defer func(v time.Duration) { parameter.AllowableDelay = v }(parameter.AllowableDelay)
+	parameter.AllowableDelay = 0
+
+	for _, c := range []struct {
+		name      string
+		listed    bool
+		fetchable bool
+		ok        bool
+	}{
+		{"unpublished", false, false, true},
+		{"still listed", true, false, false},
+		{"still fetchable", false, true, false},
+		{"still served", true, true, false},
+	} {
+		state := newTestState()
+		admin := addTestAdmin(state, 1)
+
+		mux := http.NewServeMux()
+		handleTestAdminLogin(mux, admin)
+		createdEvents := handleTestCreateEvent(mux, 0)
+		handleTestEditEvent(mux, state)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			events := []JsonEvent{}
+			if c.listed {
+				events = append(events, newTestJsonFullEvent(uint(createdEvents()), "kept", 1000, true, false).JsonEvent)
+			}
+			b, _ := json.Marshal(events)
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><body><div id="app-wrapper" data-events="%s" data-login-user="null"></div></body></html>`, html.EscapeString(string(b)))
+		})
+		mux.HandleFunc("/api/events/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if c.fetchable {
+				json.NewEncoder(w).Encode(newTestJsonFullEvent(uint(createdEvents()), "kept", 1000, true, false).JsonEvent)
+				return
+			}
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(JsonError{Error: "not_found"})
+		})
+		closeServer := startTestServer(mux)
+
+		err := CheckUnpublishEvent(context.Background(), state)
+		closeServer()
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if createdEvents() != 1 {
+			t.Errorf("%s: expected 1 event, got %d", c.name, createdEvents())
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package bench
 
 import (
+	"bench/parameter"
+	"encoding/json"
 	"log"
 	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/LK4D4/trylock"
@@ -23,6 +26,10 @@ type JsonFullUser struct {
 	TotalPrice         uint                   `json:"total_price"`
 	RecentEvents       []*JsonFullEvent       `json:"recent_events"`
 	RecentReservations []*JsonFullReservation `json:"recent_reservations"`
+
+	// Optional metadata which is not defined in the manual
+	LastLogin json.RawMessage `json:"last_login"`
+	CreatedAt json.RawMessage `json:"created_at"`
 }
 
 type JsonAdministrator struct {
@@ -173,6 +180,14 @@ type Event struct {
 	CancelCompletedRT     ReservationTickets
 }
 
+// Returns copies of the reservation tickets of each stage
+func (e *Event) GetReservationTickets() (reserveRequested, reserveCompleted, cancelRequested, cancelCompleted ReservationTickets) {
+	e.reservationMtx.RLock()
+	defer e.reservationMtx.RUnlock()
+
+	return e.ReserveRequestedRT, e.ReserveCompletedRT, e.CancelRequestedRT, e.CancelCompletedRT
+}
+
 type ReservationTickets struct {
 	S, A, B, C uint
 }
@@ -222,6 +237,7 @@ type ReportRecord struct {
 	SheetNum      uint
 	SheetPrice    uint
 	UserID        uint
+	SoldAt        time.Time
 	CanceledAt    time.Time
 }
 
@@ -243,6 +259,24 @@ type Reservation struct {
 	CancelCompletedAt  time.Time
 }
 
+// Returns a copy of r without the lock state of cancelMtx
+func (r *Reservation) Copy() *Reservation {
+	return &Reservation{
+		ID:                 r.ID,
+		EventID:            r.EventID,
+		UserID:             r.UserID,
+		SheetID:            r.SheetID,
+		SheetRank:          r.SheetRank,
+		SheetNum:           r.SheetNum,
+		Price:              r.Price,
+		ReservedAt:         r.ReservedAt,
+		CanceledAt:         r.CanceledAt,
+		ReserveCompletedAt: r.ReserveCompletedAt,
+		CancelRequestedAt:  r.CancelRequestedAt,
+		CancelCompletedAt:  r.CancelCompletedAt,
+	}
+}
+
 func (r Reservation) CancelMtx() trylock.Mutex {
 	return r.cancelMtx
 }
@@ -300,14 +334,19 @@ type EventSheet struct {
 }
 
 type State struct {
+	// The largest ID of events, accessed atomically without mtx. It comes first to be 64-bit aligned.
+	maxEventID uint64
+
 	mtx                              sync.Mutex
-	newEventMtx                      trylock.Mutex
+	newEventSem                      chan struct{} // limits # of concurrent event creations
 	getRandomPublicSoldOutEventRWMtx sync.RWMutex
 
 	users      []*AppUser
 	newUsers   []*AppUser
 	userMap    map[string]*AppUser
 	checkerMap map[*AppUser]*Checker
+	userZipf   *rand.Zipf // favors power users with smaller IDs, nil for uniform selection
+	holds      holdTracker
 
 	admins          []*Administrator
 	adminMap        map[string]*Administrator
@@ -352,22 +391,34 @@ type State struct {
 	cancelLogMtx  sync.Mutex
 	cancelLogID   uint64                  // 2^64 should be enough
 	cancelLog     map[uint64]*Reservation // key: cancelLogID
+
+	reserveRate    reserveRateTracker
+	restartSession restartSessionTracker
 }
 
 func (s *State) Init() {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
+	s.newEventSem = make(chan struct{}, parameter.MaxConcurrentEventCreations)
+
 	s.userMap = map[string]*AppUser{}
 	s.checkerMap = map[*AppUser]*Checker{}
 	for _, u := range DataSet.Users {
 		s.pushInitialUserLocked(u)
 	}
 	s.newUsers = append(s.newUsers, DataSet.NewUsers...)
+	if parameter.UserZipfS > 1 && len(DataSet.Users) > 1 {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		s.userZipf = rand.NewZipf(r, parameter.UserZipfS, 1, uint64(len(DataSet.Users)-1))
+	}
 
 	s.adminMap = map[string]*Administrator{}
 	s.adminCheckerMap = map[*Administrator]*Checker{}
-	for _, u := range DataSet.Administrators {
+	for i, u := range DataSet.Administrators {
+		if parameter.MaxAdministrators > 0 && i >= parameter.MaxAdministrators {
+			break
+		}
 		s.pushInitialAdministratorLocked(u)
 	}
 
@@ -391,6 +442,9 @@ func (s *State) Init() {
 	s.reserveLog = map[uint64]*Reservation{}
 	s.cancelLogID = 0
 	s.cancelLog = map[uint64]*Reservation{}
+
+	s.reserveRate.init()
+	s.holds.init()
 }
 
 func (s *State) PopRandomUser() (*AppUser, *Checker, func()) {
@@ -403,7 +457,7 @@ func (s *State) PopRandomUser() (*AppUser, *Checker, func()) {
 		return nil, nil, nil
 	}
 
-	i := rand.Intn(n)
+	i := s.pickUserIndexLocked()
 	u := s.users[i]
 
 	s.users[i] = s.users[n-1]
@@ -411,9 +465,34 @@ func (s *State) PopRandomUser() (*AppUser, *Checker, func()) {
 	s.users = s.users[:n-1]
 
 	log.Printf("debug: PopRandomUser %d %s %s\n", u.ID, u.LoginName, u.Nickname)
+	s.holds.acquire("user", u.ID)
 	return u, s.getCheckerLocked(u), func() { s.PushUser(u) }
 }
 
+// Picks a user uniformly, or by Zipf distribution over IDs if parameter.UserZipfS is set.
+// In the latter case, the user who has the smallest ID not less than the drawn one is picked
+// because power users may be popped by other scenarios.
+func (s *State) pickUserIndexLocked() int {
+	if s.userZipf == nil {
+		return rand.Intn(len(s.users))
+	}
+
+	id := uint(s.userZipf.Uint64()) + 1
+	picked, fallback := -1, 0
+	for i, u := range s.users {
+		if u.ID >= id && (picked < 0 || u.ID < s.users[picked].ID) {
+			picked = i
+		}
+		if u.ID > s.users[fallback].ID {
+			fallback = i
+		}
+	}
+	if picked < 0 {
+		return fallback
+	}
+	return picked
+}
+
 func (s *State) PopUserByID(userID uint) (*AppUser, *Checker, func()) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -426,8 +505,9 @@ func (s *State) PopUserByID(userID uint) (*AppUser, *Checker, func()) {
 
 	var i int
 	var u *AppUser
-	for i, u = range s.users {
-		if u.ID == userID {
+	for j, v := range s.users {
+		if v.ID == userID {
+			i, u = j, v
 			break
 		}
 	}
@@ -442,6 +522,7 @@ func (s *State) PopUserByID(userID uint) (*AppUser, *Checker, func()) {
 	s.users = s.users[:n-1]
 
 	log.Printf("debug: PopUserByID %d %s %s\n", u.ID, u.LoginName, u.Nickname)
+	s.holds.acquire("user", u.ID)
 	return u, s.getCheckerLocked(u), func() { s.PushUser(u) }
 }
 
@@ -450,6 +531,7 @@ func (s *State) PushUser(u *AppUser) {
 	defer s.mtx.Unlock()
 
 	log.Printf("debug: PushUser %d %s %s\n", u.ID, u.LoginName, u.Nickname)
+	s.holds.release("user", u.ID)
 	s.users = append(s.users, u)
 }
 
@@ -492,6 +574,28 @@ func (s *State) pushInitialUserLocked(u *AppUser) {
 	s.users = append(s.users, u)
 }
 
+// Numbers of users and administrators popped by scenarios, and non-reserved sheets of public events
+type PoolUsage struct {
+	UsersInUse  int
+	Users       int
+	AdminsInUse int
+	Admins      int
+	EventSheets int
+}
+
+func (s *State) GetPoolUsage() PoolUsage {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return PoolUsage{
+		UsersInUse:  len(s.userMap) - len(s.users),
+		Users:       len(s.userMap),
+		AdminsInUse: len(s.adminMap) - len(s.admins),
+		Admins:      len(s.adminMap),
+		EventSheets: len(s.eventSheets),
+	}
+}
+
 func (s *State) GetChecker(u *AppUser) *Checker {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -521,13 +625,20 @@ func (s *State) PopRandomAdministrator() (*Administrator, *Checker, func()) {
 		return nil, nil, nil
 	}
 
-	i := rand.Intn(n)
-	u := s.admins[i]
-
-	s.admins[i] = s.admins[n-1]
+	var u *Administrator
+	if parameter.AdministratorRoundRobin {
+		// Pushed administrators go to the tail, so the head is the one idle for the longest time
+		u = s.admins[0]
+		copy(s.admins, s.admins[1:])
+	} else {
+		i := rand.Intn(n)
+		u = s.admins[i]
+		s.admins[i] = s.admins[n-1]
+	}
 	s.admins[n-1] = nil
 	s.admins = s.admins[:n-1]
 
+	s.holds.acquire("admin", u.ID)
 	return u, s.getAdminCheckerLocked(u), func() { s.PushAdministrator(u) }
 }
 
@@ -535,6 +646,7 @@ func (s *State) PushAdministrator(u *Administrator) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
+	s.holds.release("admin", u.ID)
 	s.admins = append(s.admins, u)
 }
 
@@ -588,6 +700,7 @@ func (s *State) pushNewEventLocked(event *Event, createdAt time.Time, caller str
 
 	event.CreatedAt = createdAt
 	s.events = append(s.events, event)
+	s.updateMaxEventID(event.ID)
 
 	if event.IsSoldOut() {
 		return
@@ -619,6 +732,7 @@ func (s *State) pushNewEventLocked(event *Event, createdAt time.Time, caller str
 func (s *State) pushInitialClosedEventLocked(event *Event, createdAt time.Time) {
 	event.CreatedAt = createdAt
 	s.events = append(s.events, event)
+	s.updateMaxEventID(event.ID)
 
 	for _, sheetKind := range DataSet.SheetKinds {
 		for i := uint(0); i < sheetKind.Total; i++ {
@@ -663,6 +777,21 @@ func (s *State) GetEventByID(eventID uint) *Event {
 	return nil
 }
 
+func (s *State) updateMaxEventID(id uint) {
+	for {
+		maxID := atomic.LoadUint64(&s.maxEventID)
+		if uint64(id) <= maxID || atomic.CompareAndSwapUint64(&s.maxEventID, maxID, uint64(id)) {
+			return
+		}
+	}
+}
+
+// Returns a well-formed event ID which is far beyond existing ones, so it will not be created during the benchmark.
+// It does not wait for mtx held by concurrent event creations.
+func (s *State) GetNonexistentEventID() uint {
+	return uint(atomic.LoadUint64(&s.maxEventID)) + 1000000 + uint(rand.Intn(1000000))
+}
+
 // Returns a deep copy of s.events
 func (s *State) GetCopiedEvents() (events []*Event) {
 	s.mtx.Lock()
@@ -775,6 +904,15 @@ func GetSheetKindByRank(rank string) *SheetKind {
 	return nil
 }
 
+// Returns whether num is in [1, total] of the rank
+func ValidSheetNum(rank string, num uint) bool {
+	sheetKind := GetSheetKindByRank(rank)
+	if sheetKind == nil {
+		return false
+	}
+	return 1 <= num && num <= sheetKind.Total
+}
+
 func GetRandomSheetNum(sheetRank string) uint {
 	total := uint(0)
 	for _, sheetKind := range DataSet.SheetKinds {
@@ -782,7 +920,7 @@ func GetRandomSheetNum(sheetRank string) uint {
 			total = sheetKind.Total
 		}
 	}
-	return uint(rand.Intn(int(total)))
+	return 1 + uint(rand.Intn(int(total)))
 }
 
 func (s *State) FindReservationByID(reservationID uint) *Reservation {
@@ -794,6 +932,19 @@ func (s *State) FindReservationByID(reservationID uint) *Reservation {
 	return reservation
 }
 
+// Returns the live reservation if it is reserved and not canceled yet, otherwise nil
+func (s *State) FindCancelableReservationByID(reservationID uint) *Reservation {
+	s.reservationMtx.Lock()
+	defer s.reservationMtx.Unlock()
+
+	reservation := s.reservations[reservationID]
+	if reservation == nil || reservation.ReserveCompletedAt.IsZero() || !reservation.CancelRequestedAt.IsZero() {
+		return nil
+	}
+
+	return reservation
+}
+
 // Returns a shallow copy of s.reservations
 func (s *State) GetReservations() map[uint]*Reservation {
 	s.reservationMtx.Lock()
@@ -818,8 +969,7 @@ func (s *State) GetCopiedReservations() map[uint]*Reservation {
 
 	reservations := make(map[uint]*Reservation, len(s.reservations))
 	for id, r := range s.reservations {
-		reservation := *r // copy
-		reservations[id] = &reservation
+		reservations[id] = r.Copy()
 	}
 
 	log.Println("debug: GetCopiedReservations", time.Since(t))
@@ -852,8 +1002,22 @@ func (s *State) GetCopiedReservationsInEventID(eventID uint) map[uint]*Reservati
 		if r.EventID != eventID {
 			continue
 		}
-		reservation := *r // copy
-		filtered[id] = &reservation
+		filtered[id] = r.Copy()
+	}
+	return filtered
+}
+
+// Returns a deep copy of reservations of the user
+func (s *State) GetCopiedReservationsInUserID(userID uint) map[uint]*Reservation {
+	s.reservationMtx.Lock()
+	defer s.reservationMtx.Unlock()
+
+	filtered := map[uint]*Reservation{}
+	for id, r := range s.reservations {
+		if r.UserID != userID {
+			continue
+		}
+		filtered[id] = r.Copy()
 	}
 	return filtered
 }
@@ -894,6 +1058,45 @@ func (s *State) GetRandomNonCanceledReservationInEventID(eventID uint) *Reservat
 	return filtered[i]
 }
 
+// Returns two reservations which are not canceled but own the same sheet, or nils if there is no such pair
+func (s *State) FindDoubleBookedSheet(eventID uint) (*Reservation, *Reservation) {
+	reservations := s.GetCopiedReservationsInEventID(eventID)
+
+	owners := make(map[string]*Reservation, len(reservations))
+	for _, reservation := range reservations {
+		if !reservation.CancelRequestedAt.IsZero() {
+			continue
+		}
+		key := reservation.SheetRank + "-" + strconv.Itoa(int(reservation.SheetNum))
+		if owner, ok := owners[key]; ok {
+			return owner, reservation
+		}
+		owners[key] = reservation
+	}
+	return nil, nil
+}
+
+type BookingCounts struct {
+	ReserveCommitted uint
+	ReserveFailed    uint // requested but not completed, i.e., failed or timed out
+	CancelCommitted  uint
+	CancelFailed     uint
+}
+
+// Returns counts of reservations and cancelations made by the benchmarker, excluding the initial data set
+func (s *State) GetBookingCounts() BookingCounts {
+	s.reservationMtx.Lock()
+	defer s.reservationMtx.Unlock()
+
+	initial := uint(len(DataSet.Reservations))
+	return BookingCounts{
+		ReserveCommitted: s.reserveCompletedCount - initial,
+		ReserveFailed:    s.reserveRequestedCount - s.reserveCompletedCount,
+		CancelCommitted:  s.cancelCompletedCount,
+		CancelFailed:     s.cancelRequestedCount - s.cancelCompletedCount,
+	}
+}
+
 func (s *State) GetReserveRequestedCount() uint {
 	s.reserveLogMtx.Lock()
 	defer s.reserveLogMtx.Unlock()
@@ -901,6 +1104,14 @@ func (s *State) GetReserveRequestedCount() uint {
 	return s.reserveRequestedCount
 }
 
+// Returns # of reserve requests whose results are not known yet, i.e., in-flight or failed ones
+func (s *State) GetReserveLogCount() int {
+	s.reserveLogMtx.Lock()
+	defer s.reserveLogMtx.Unlock()
+
+	return len(s.reserveLog)
+}
+
 func (e *Event) GetReserveRequestedCount() uint {
 	e.reservationMtx.Lock()
 	defer e.reservationMtx.Unlock()
@@ -938,7 +1149,11 @@ func (s *State) CommitReservation(logID uint64, lockedUser *AppUser, reservation
 		s.reservationMtx.Lock()
 		defer s.reservationMtx.Unlock()
 
+		if reservation.ID == 0 {
+			return fatalErrorf("予約IDが返されていません")
+		}
 		if _, ok := s.reservations[reservation.ID]; ok {
+			log.Printf("warn: reservation id=%d is returned again (eventID:%d)\n", reservation.ID, reservation.EventID)
 			return fatalErrorf("予約IDが重複しています")
 		}
 
@@ -951,6 +1166,7 @@ func (s *State) CommitReservation(logID uint64, lockedUser *AppUser, reservation
 	if err != nil {
 		return err
 	}
+	s.reserveRate.record(reservation.EventID, reservation.ReserveCompletedAt)
 	func() {
 		event := s.FindEventByID(reservation.EventID)
 		rank := reservation.SheetRank
@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetPoolUsage(t *testing.T) {
+	state := newTestState()
+	addTestUser(state, 1)
+	addTestUser(state, 2)
+	addTestEvent(state, 1, 1000)
+
+	user, _, push := state.PopRandomUser()
+	if user == nil {
+		t.Fatal("no user")
+	}
+	usage := state.GetPoolUsage()
+	if usage.UsersInUse != 1 || usage.Users != 2 {
+		t.Errorf("expected 1/2 users in use, got %d/%d", usage.UsersInUse, usage.Users)
+	}
+	if usage.EventSheets != int(DataSet.SheetTotal) {
+		t.Errorf("expected %d event sheets, got %d", DataSet.SheetTotal, usage.EventSheets)
+	}
+
+	push()
+	if usage := state.GetPoolUsage(); usage.UsersInUse != 0 {
+		t.Errorf("expected no users in use, got %d", usage.UsersInUse)
+	}
+}
+
+func TestCommitReservationDuplicatedID(t *testing.T) {
+	state := newTestState()
+	user1 := addTestUser(state, 1)
+	user2 := addTestUser(state, 2)
+	addTestEvent(state, 1, 1000)
+
+	addTestReservation(state, user1, &Reservation{ID: 1, EventID: 1, UserID: user1.ID, SheetRank: "S", SheetNum: 1, Price: 6000})
+
+	// The server returns the same id for another user's reservation
+	reservation := &Reservation{ID: 1, EventID: 1, UserID: user2.ID, SheetRank: "A", SheetNum: 1, Price: 4000}
+	logID := state.BeginReservation(user2, reservation)
+	err := state.CommitReservation(logID, user2, reservation)
+	if !IsFatal(err) || !strings.Contains(err.Error(), "予約IDが重複しています") {
+		t.Errorf("expected a duplicated id error, got %v", err)
+	}
+	if r := state.FindReservationByID(1); r.UserID != user1.ID {
+		t.Errorf("the first reservation is overwritten by %+v", r)
+	}
+
+	reservation = &Reservation{ID: 0, EventID: 1, UserID: user2.ID, SheetRank: "A", SheetNum: 2, Price: 4000}
+	logID = state.BeginReservation(user2, reservation)
+	err = state.CommitReservation(logID, user2, reservation)
+	if !IsFatal(err) || !strings.Contains(err.Error(), "予約IDが返されていません") {
+		t.Errorf("expected a missing id error, got %v", err)
+	}
+}
+
+func TestGetNonexistentEventIDConcurrentCreations(t *testing.T) {
+	state := newTestState()
+
+	// IDs jump in the middle like the auto increment of a restored database
+	var pushed uint64
+	var ids []uint
+	for i := uint(1); i <= 100; i++ {
+		ids = append(ids, i)
+	}
+	for i := uint(3000000); i < 3000100; i++ {
+		ids = append(ids, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, id := range ids {
+			addTestEvent(state, id, 1000)
+			atomic.StoreUint64(&pushed, uint64(id))
+		}
+	}()
+
+	var mtx sync.Mutex
+	var nonexistentIDs []uint
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				before := uint(atomic.LoadUint64(&pushed))
+				id := state.GetNonexistentEventID()
+				if id <= before {
+					t.Errorf("nonexistent id %d is not larger than the created id %d", id, before)
+					return
+				}
+				mtx.Lock()
+				nonexistentIDs = append(nonexistentIDs, id)
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	for _, id := range nonexistentIDs {
+		if state.FindEventByID(id) != nil {
+			t.Fatalf("nonexistent id %d collides with an event", id)
+		}
+	}
+	if id := state.GetNonexistentEventID(); id <= 3000099 {
+		t.Errorf("nonexistent id %d is not larger than the largest id", id)
+	}
+}
+
+func TestReservationCopy(t *testing.T) {
+	now := time.Now()
+	r := &Reservation{
+		ID: 1, EventID: 2, UserID: 3, SheetID: 4, SheetRank: "S", SheetNum: 5, Price: 6000, ReservedAt: 7, CanceledAt: 8,
+		ReserveCompletedAt: now, CancelRequestedAt: now.Add(time.Second), CancelCompletedAt: now.Add(2 * time.Second),
+	}
+	r.cancelMtx.Lock()
+	defer r.cancelMtx.Unlock()
+
+	copied := r.Copy()
+	// Every exported field is copied
+	v, c := reflect.ValueOf(r).Elem(), reflect.ValueOf(copied).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), c.Field(i).Interface()) {
+			t.Errorf("%s is not copied: %v", field.Name, c.Field(i).Interface())
+		}
+	}
+	if !copied.cancelMtx.TryLock() {
+		t.Error("expected the copy not to inherit the lock")
+	}
+}
+
+func TestGetRandomSheetNum(t *testing.T) {
+	for _, sheetKind := range DataSet.SheetKinds {
+		for i := 0; i < 10*int(sheetKind.Total); i++ {
+			num := GetRandomSheetNum(sheetKind.Rank)
+			if num < 1 || sheetKind.Total < num {
+				t.Fatalf("sheet %s-%d is out of 1..%d", sheetKind.Rank, num, sheetKind.Total)
+			}
+		}
+	}
+}
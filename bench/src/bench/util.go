@@ -2,11 +2,17 @@ package bench
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 func assert(flag bool, msgs ...interface{}) {
@@ -23,6 +29,39 @@ func must(err error) {
 	}
 }
 
+// Decodes a JSON response body into v, or returns the standard fatal error with the raw body
+func decodeJSON(body *bytes.Buffer, v interface{}) error {
+	raw := body.Bytes()
+	err := json.NewDecoder(body).Decode(v)
+	if err != nil {
+		return fatalErrorf("Jsonのデコードに失敗 %s %v", string(raw), err)
+	}
+	return nil
+}
+
+type strictJSONKey struct{}
+
+// Decodes a JSON response body like decodeJSON, but disallows unknown fields if the action is StrictJSON
+func decodeResponseJSON(res *http.Response, body *bytes.Buffer, v interface{}) error {
+	if !isStrictJSON(res) {
+		return decodeJSON(body, v)
+	}
+
+	raw := body.Bytes()
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return fatalErrorf("Jsonのデコードに失敗 %s %v", string(raw), err)
+	}
+	return nil
+}
+
+// Returns true if the response is of a StrictJSON action
+func isStrictJSON(res *http.Response) bool {
+	return res.Request != nil && res.Request.Context().Value(strictJSONKey{}) != nil
+}
+
 func trim(s string) string {
 	return strings.TrimSpace(s)
 }
@@ -37,6 +76,16 @@ func RandomAlphabetString(n int) string {
 	return string(b)
 }
 
+// Reads the same byte forever. Use with io.LimitReader.
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
 var bytesBufferPool = sync.Pool{
 	New: func() interface{} {
 		return new(bytes.Buffer)
@@ -52,6 +101,45 @@ func PutBuffer(buf *bytes.Buffer) {
 	bytesBufferPool.Put(buf)
 }
 
+// Returns the hex encoded md5 of b.
+// md5.Sum into an array allocates no hasher, so it needs no pool of hashers either.
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Counts bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
 func JoinCrc32(crcSum []byte) uint32 {
 	return uint32(crcSum[0])<<24 | uint32(crcSum[1])<<16 | uint32(crcSum[2])<<8 | uint32(crcSum[3])
 }
+
+// Parses an optional timestamp field formatted in RFC3339 or unix time.
+// ok is false if the field is absent or null.
+func parseOptionalTimestamp(raw json.RawMessage) (t time.Time, ok bool, err error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, false, nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		t, err = time.Parse(time.RFC3339, str)
+		return t, err == nil, err
+	}
+
+	var unix int64
+	if err := json.Unmarshal(raw, &unix); err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(unix, 0), true, nil
+}
@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	var v JsonUser
+	if err := decodeJSON(bytes.NewBufferString(`{"id":1,"nickname":"sonots"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != 1 || v.Nickname != "sonots" {
+		t.Errorf("unexpected %+v", v)
+	}
+
+	body := `{"id":"1"`
+	err := decodeJSON(bytes.NewBufferString(body), &v)
+	if !IsFatal(err) {
+		t.Fatalf("expected a fatal error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Jsonのデコードに失敗 "+body+" ") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+func TestCheckJsonResponsesReportDecodeErrors(t *testing.T) {
+	checks := map[string]func(res *http.Response, body *bytes.Buffer) error{
+		"user":          checkJsonUserResponse(&AppUser{ID: 1}),
+		"administrator": checkJsonAdministratorResponse(&Administrator{ID: 1}),
+		"event":         checkJsonFullEventResponse(&Event{ID: 1}),
+	}
+	for name, check := range checks {
+		err := check(&http.Response{}, bytes.NewBufferString("<html>"))
+		if err == nil || !strings.Contains(err.Error(), "Jsonのデコードに失敗 <html> ") {
+			t.Errorf("%s: expected the standard decode error, got %v", name, err)
+		}
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	user := &AppUser{Nickname: "sonots"}
+	event := &Event{Title: "event", Price: 1000}
+	jsonEvent := newTestJsonFullEvent(1, event.Title, event.Price, false, false)
+	rawEvent, _ := json.Marshal(jsonEvent)
+	noSheets := jsonEvent
+	noSheets.Sheets = nil
+	rawNoSheets, _ := json.Marshal(noSheets)
+
+	var response string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	})
+	defer startTestServer(mux)()
+
+	cases := []struct {
+		name      string
+		response  string
+		checkFunc func(*http.Response, *bytes.Buffer) error
+		strict    bool
+		ok        bool
+	}{
+		{"user", `{"id":1,"nickname":"sonots"}`, checkJsonUserCreateResponse(user), true, true},
+		{"user with an extra key", `{"id":1,"nickname":"sonots","password":"x"}`, checkJsonUserCreateResponse(user), true, false},
+		{"user without id", `{"nickname":"sonots"}`, checkJsonUserCreateResponse(user), true, false},
+		{"user with an extra key not strictly", `{"id":1,"nickname":"sonots","password":"x"}`, checkJsonUserCreateResponse(user), false, true},
+		{"user without id not strictly", `{"nickname":"sonots"}`, checkJsonUserCreateResponse(user), false, true},
+		{"event", string(rawEvent), checkJsonFullEventCreateResponse(event), true, true},
+		{"event with an extra key", strings.Replace(string(rawEvent), `{`, `{"created_at":0,`, 1), checkJsonFullEventCreateResponse(event), true, false},
+		{"event without sheets", string(rawNoSheets), checkJsonFullEventCreateResponse(event), true, false},
+		{"event without id", strings.Replace(string(rawEvent), `"id":1,`, ``, 1), checkJsonFullEventCreateResponse(event), true, false},
+	}
+	for _, c := range cases {
+		response = c.response
+		err := NewChecker().Play(context.Background(), &CheckAction{
+			Method:     "POST",
+			Path:       "/",
+			StrictJSON: c.strict,
+			CheckFunc:  c.checkFunc,
+		})
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if !c.ok && !IsCheckerFatal(err) {
+			t.Errorf("%s: expected a fatal error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestMD5Hex(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, i*1000)
+		hasher := md5.New()
+		hasher.Write(content)
+		if got, want := md5Hex(content), hex.EncodeToString(hasher.Sum(nil)); got != want {
+			t.Errorf("md5 of %d bytes: got %s, expected %s", len(content), got, want)
+		}
+	}
+	// The digest of the empty content
+	if got := md5Hex(nil); got != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("unexpected md5 of empty content %s", got)
+	}
+}
+
+// Compares allocations of a hasher per file, as CheckStaticFiles did before, and md5Hex.
+// Run with -benchmem
+func BenchmarkMD5Hex(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	b.Run("hasher", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hasher := md5.New()
+			hasher.Write(content)
+			hex.EncodeToString(hasher.Sum(nil))
+		}
+	})
+	b.Run("md5Hex", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			md5Hex(content)
+		}
+	})
+}
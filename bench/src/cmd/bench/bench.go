@@ -13,9 +13,12 @@ import (
 	_ "net/http/pprof"
 	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"bench"
@@ -35,8 +38,24 @@ var (
 	loadLevelUpFuncs []benchFunc
 	postTestFuncs    []benchFunc
 	loadLogs         []string
+	importLedgerPath string
+	exportLedgerPath string
+	trafficMixPath   string
 
 	pprofPort int = 16060
+
+	// Receives a 5xx error from load funcs if parameter.FailFastOnServerError
+	loadErrCh = make(chan error, 1)
+
+	// Canceled by SIGINT or SIGTERM to stop the benchmark and report partial results
+	shutdownCtx, shutdown = context.WithCancel(context.Background())
+
+	// Goroutines started by loadMain, which waits for them after the context is done
+	loadWorkers sync.WaitGroup
+
+	// The state of the running benchmark, which is notified on SIGUSR1 that the webapp has been restarted
+	runningStateMtx sync.Mutex
+	runningState    *bench.State
 )
 
 type benchFunc struct {
@@ -71,7 +90,7 @@ func addPostTestFunc(f benchFunc) {
 
 func requestInitialize(targetHost string) error {
 	u, _ := url.Parse("/initialize")
-	u.Scheme = "http"
+	u.Scheme = bench.TargetScheme
 	u.Host = targetHost
 
 	req, err := http.NewRequest("GET", u.String(), nil)
@@ -83,7 +102,8 @@ func requestInitialize(targetHost string) error {
 	req.Host = bench.TorbAppHost
 
 	client := &http.Client{
-		Timeout: bench.InitializeTimeout,
+		Timeout:   bench.InitializeTimeout,
+		Transport: &http.Transport{TLSClientConfig: bench.TLSClientConfig},
 	}
 
 	res, err := client.Do(req)
@@ -198,6 +218,8 @@ func checkMain(ctx context.Context, state *bench.State) error {
 					time.Sleep(parameter.WaitOnError)
 				}
 			}
+		case err := <-loadErrCh:
+			return err
 		case <-ctx.Done():
 			// benchmarker timeout
 			return nil
@@ -225,6 +247,16 @@ func checkMain(ctx context.Context, state *bench.State) error {
 	}
 }
 
+func notifyLoadServerError(err error) {
+	if !parameter.FailFastOnServerError || !bench.IsCheckerServerError(err) {
+		return
+	}
+	select {
+	case loadErrCh <- err:
+	default:
+	}
+}
+
 func goLoadFuncs(ctx context.Context, state *bench.State, n int) {
 	sumWait := (n - 1) * n / 2
 	waits := rand.Perm(n)
@@ -235,24 +267,33 @@ func goLoadFuncs(ctx context.Context, state *bench.State, n int) {
 		delay := time.Duration(float64(waits[i])/float64(sumWait)*parameter.LoadStartupTotalWait) * time.Microsecond
 		time.Sleep(delay)
 		sumDelay += delay
+		if ctx.Err() != nil {
+			break
+		}
 
+		loadWorkers.Add(1)
 		go func() {
+			defer loadWorkers.Done()
 			for {
 				if ctx.Err() != nil {
 					return
 				}
 
-				loadFunc := loadFuncs[rand.Intn(len(loadFuncs))]
+				loadFunc := pickLoadFunc()
 				t := time.Now()
 				err := loadFunc.Func(ctx, state)
 				log.Println("debug: loadFunc:", loadFunc.Name, time.Since(t))
 
 				if err != nil {
+					notifyLoadServerError(err)
 					// バリデーションシナリオを悪用してスコアブーストさせないためエラーのときは少し待つ
-					time.Sleep(parameter.WaitOnError)
+					select {
+					case <-time.After(parameter.WaitOnError):
+					case <-ctx.Done():
+					}
 				}
 
-				// no fail
+				// no fail unless FailFastOnServerError
 			}
 		}()
 	}
@@ -269,41 +310,83 @@ func goLoadLevelUpFuncs(ctx context.Context, state *bench.State, n int) {
 		delay := time.Duration(float64(waits[i])/float64(sumWait)*parameter.LoadStartupTotalWait) * time.Microsecond
 		time.Sleep(delay)
 		sumDelay += delay
+		if ctx.Err() != nil {
+			break
+		}
 
+		loadWorkers.Add(1)
 		go func() {
+			defer loadWorkers.Done()
 			for {
 				if ctx.Err() != nil {
 					return
 				}
 
-				loadFunc := loadLevelUpFuncs[rand.Intn(len(loadLevelUpFuncs))]
+				loadFunc := pickLoadLevelUpFunc()
 				t := time.Now()
 				err := loadFunc.Func(ctx, state)
 				log.Println("debug: levelUpFunc:", loadFunc.Name, time.Since(t))
 
 				if err != nil {
+					notifyLoadServerError(err)
 					// バリデーションシナリオを悪用してスコアブーストさせないためエラーのときは少し待つ
-					time.Sleep(parameter.WaitOnError)
+					select {
+					case <-time.After(parameter.WaitOnError):
+					case <-ctx.Done():
+					}
 				}
 
-				// no fail
+				// no fail unless FailFastOnServerError
 			}
 		}()
 	}
 	log.Println("debug: goLoadLevelUpFuncs wait totally", sumDelay)
 }
 
+// Waits for the load goroutines, the in-flight requests and the asset workers to return
+// after the benchmark is interrupted. Returns false on timeout.
+func waitShutdown(loadDone <-chan struct{}, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	select {
+	case <-loadDone:
+	case <-time.After(timeout):
+		return false
+	}
+	if !bench.WaitInFlightRequests(time.Until(deadline)) {
+		return false
+	}
+	bench.StopAssetPool()
+	return true
+}
+
+// Discards metrics of warmup except the load level.
+// Requests completed after here are scored, even if they were sent during warmup.
+func finishWarmup() {
+	levels := counter.GetKey("load-level-up")
+	counter.Reset()
+	counter.AddKey("load-level-up", int(levels))
+}
+
 func loadMain(ctx context.Context, state *bench.State) {
 	levelUpRatio := parameter.LoadLevelUpRatio
 	numGoroutines := parameter.LoadInitialNumGoroutines
+	trafficMixStart = time.Now()
 
 	goLoadFuncs(ctx, state, int(numGoroutines))
 
 	levelUpTicker := time.NewTicker(parameter.LoadLevelUpInterval)
 	defer levelUpTicker.Stop()
 
+	var warmupEnd <-chan time.Time
+	if parameter.WarmupDuration > 0 {
+		warmupEnd = time.After(parameter.WarmupDuration)
+	}
+
 	for {
 		select {
+		case <-warmupEnd:
+			finishWarmup()
+			log.Println("Warmup finished")
 		case <-levelUpTicker.C:
 			log.Printf("debug: loadLevel:%d numGoroutines:%d runtime.NumGoroutines():%d\n", counter.GetKey("load-level-up"), int(numGoroutines), runtime.NumGoroutine())
 			if noLevelup {
@@ -335,6 +418,7 @@ func loadMain(ctx context.Context, state *bench.State) {
 		case <-ctx.Done():
 			// ベンチ終了、このタイミングでエラーの収集をやめる。
 			bench.GuardCheckerError(true)
+			loadWorkers.Wait()
 			return
 		}
 	}
@@ -388,6 +472,56 @@ func printCounterSummary() {
 			log.Println(kv.Key, kv.Value)
 		}
 	}
+	newConn := counter.GetKey("conn-new")
+	reusedConn := counter.GetKey("conn-reused")
+	if newConn+reusedConn > 0 {
+		log.Printf("conn-reuse-ratio %.3f\n", float64(reusedConn)/float64(newConn+reusedConn))
+	}
+	log.Println("-------------------------")
+}
+
+func printLoginSummary() {
+	loginTime := counter.GetKey("login-time-us")
+	requestTime := counter.GetKey("request-time-us")
+	logins := counter.GetKey("login-count")
+	skipped := counter.GetKey("login-skipped")
+
+	log.Println("----- Login -----")
+	log.Println("login-count", logins)
+	log.Println("login-skipped", skipped)
+	if logins+skipped > 0 {
+		log.Printf("session-reuse-ratio %.3f\n", float64(skipped)/float64(logins+skipped))
+	}
+	if requestTime > 0 {
+		log.Printf("login-time-ratio %.3f\n", float64(loginTime)/float64(requestTime))
+	}
+	log.Println("-------------------------")
+}
+
+func printLatencySummary() {
+	log.Println("----- Latency -----")
+	for _, key := range counter.ObservedKeys() {
+		log.Printf("%s count=%d p50=%s p90=%s p99=%s\n", key, counter.ObservedCount(key),
+			counter.Percentile(key, 50), counter.Percentile(key, 90), counter.Percentile(key, 99))
+	}
+	log.Println("-------------------------")
+}
+
+func printBookingSummary(state *bench.State) {
+	c := state.GetBookingCounts()
+	log.Println("----- Bookings -----")
+	log.Println("reserve-committed", c.ReserveCommitted)
+	log.Println("reserve-failed", c.ReserveFailed)
+	log.Println("cancel-committed", c.CancelCommitted)
+	log.Println("cancel-failed", c.CancelFailed)
+	log.Println("-------------------------")
+}
+
+func printHotEventSummary(state *bench.State) {
+	log.Println("----- Hottest events -----")
+	for _, r := range state.GetHottestEvents() {
+		log.Printf("event:%d peak %.1f reservations/s at %s\n", r.EventID, r.Rate, r.At.Format("15:04:05"))
+	}
 	log.Println("-------------------------")
 }
 
@@ -397,6 +531,8 @@ func startBenchmark(remoteAddrs []string) *BenchResult {
 	addLoadFunc(10, benchFunc{"LoadEventReport", bench.LoadEventReport})
 	addLoadFunc(10, benchFunc{"LoadAdminTopPage", bench.LoadAdminTopPage})
 	addLoadFunc(1, benchFunc{"LoadReport", bench.LoadReport})
+	addLoadFunc(5, benchFunc{"LoadReservationChurn", bench.LoadReservationChurn})
+	addLoadFunc(2, benchFunc{"LoadSingleUserReserveBurst", bench.LoadSingleUserReserveBurst})
 	addLoadAndLevelUpFunc(30, benchFunc{"LoadTopPage", bench.LoadTopPage})
 	addLoadAndLevelUpFunc(10, benchFunc{"LoadReserveCancelSheet", bench.LoadReserveCancelSheet})
 	addLoadAndLevelUpFunc(20, benchFunc{"LoadReserveSheet", bench.LoadReserveSheet})
@@ -413,10 +549,54 @@ func startBenchmark(remoteAddrs []string) *BenchResult {
 	addCheckFunc(benchFunc{"CheckMyPage", bench.CheckMyPage})
 	addCheckFunc(benchFunc{"CheckCancelReserveSheet", bench.CheckCancelReserveSheet})
 	addCheckFunc(benchFunc{"CheckGetEvent", bench.CheckGetEvent})
+	addCheckFunc(benchFunc{"CheckCloseFreezesCancel", bench.CheckCloseFreezesCancel})
+	addCheckFunc(benchFunc{"CheckReportSheetRank", bench.CheckReportSheetRank})
+	addCheckFunc(benchFunc{"CheckOversizedRequestBody", bench.CheckOversizedRequestBody})
+	addCheckFunc(benchFunc{"CheckReserveOversizedRank", bench.CheckReserveOversizedRank})
+	addCheckFunc(benchFunc{"CheckGetEventAnonymous", bench.CheckGetEventAnonymous})
+	addCheckFunc(benchFunc{"CheckCreateEventSheets", bench.CheckCreateEventSheets})
+	addCheckFunc(benchFunc{"CheckEventReportIsolation", bench.CheckEventReportIsolation})
+	addCheckFunc(benchFunc{"CheckMyPageAuthorization", bench.CheckMyPageAuthorization})
+	addCheckFunc(benchFunc{"CheckReportLockContention", bench.CheckReportLockContention})
+	addCheckFunc(benchFunc{"CheckEventReportManyCancels", bench.CheckEventReportManyCancels})
+	addCheckFunc(benchFunc{"CheckSingleUserReserveBurst", bench.CheckSingleUserReserveBurst})
+	addCheckFunc(benchFunc{"CheckEditEventInvalidPublic", bench.CheckEditEventInvalidPublic})
+	addCheckFunc(benchFunc{"CheckReserveCancelRace", bench.CheckReserveCancelRace})
+	addCheckFunc(benchFunc{"CheckCreateEventDefaultPrivate", bench.CheckCreateEventDefaultPrivate})
+	addCheckFunc(benchFunc{"CheckReportExpiredAdminSession", bench.CheckReportExpiredAdminSession})
+	addCheckFunc(benchFunc{"CheckReportIncludesNewReservation", bench.CheckReportIncludesNewReservation})
+	addCheckFunc(benchFunc{"CheckTopPageAnonymous", bench.CheckTopPageAnonymous})
+	addCheckFunc(benchFunc{"CheckReservationNotExpired", bench.CheckReservationNotExpired})
+	addCheckFunc(benchFunc{"CheckEventReportMalformedID", bench.CheckEventReportMalformedID})
+	addCheckFunc(benchFunc{"CheckReportManyUsers", bench.CheckReportManyUsers})
+	addCheckFunc(benchFunc{"CheckEventDetailMatchesReport", bench.CheckEventDetailMatchesReport})
+	addCheckFunc(benchFunc{"CheckReserveSheetRankCase", bench.CheckReserveSheetRankCase})
+	addCheckFunc(benchFunc{"CheckReserveAfterSignup", bench.CheckReserveAfterSignup})
+	addCheckFunc(benchFunc{"CheckReserveTrailingSlash", bench.CheckReserveTrailingSlash})
+	addCheckFunc(benchFunc{"CheckCancelFreesSheet", bench.CheckCancelFreesSheet})
+	addCheckFunc(benchFunc{"CheckReserveSoldOut", bench.CheckReserveSoldOut})
+	addCheckFunc(benchFunc{"CheckCancelOthersReservation", bench.CheckCancelOthersReservation})
+	addCheckFunc(benchFunc{"CheckReportSpecialCharacterTitle", bench.CheckReportSpecialCharacterTitle})
+	addCheckFunc(benchFunc{"CheckSessionAcrossRestart", bench.CheckSessionAcrossRestart})
+	addCheckFunc(benchFunc{"CheckEventCap", bench.CheckEventCap})
+	addCheckFunc(benchFunc{"CheckConcurrentSameUserLogin", bench.CheckConcurrentSameUserLogin})
+	addCheckFunc(benchFunc{"CheckCancelNotReservedEachRank", bench.CheckCancelNotReservedEachRank})
+	addCheckFunc(benchFunc{"CheckConcurrentReserve", bench.CheckConcurrentReserve})
+	addCheckFunc(benchFunc{"CheckPrivateEventHidden", bench.CheckPrivateEventHidden})
+	addCheckFunc(benchFunc{"CheckUnpublishEvent", bench.CheckUnpublishEvent})
+	addCheckFunc(benchFunc{"CheckSessionInvalidation", bench.CheckSessionInvalidation})
 
 	addEveryCheckFunc(benchFunc{"CheckSheetReservationEntropy", bench.CheckSheetReservationEntropy})
 
 	addPostTestFunc(benchFunc{"CheckReport", bench.CheckReport})
+	addPostTestFunc(benchFunc{"CheckReportMatchesMyPage", bench.CheckReportMatchesMyPage})
+
+	if trafficMixPath != "" {
+		err := loadTrafficMix(trafficMixPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 
 	result := new(BenchResult)
 	result.StartTime = time.Now()
@@ -424,33 +604,42 @@ func startBenchmark(remoteAddrs []string) *BenchResult {
 		result.EndTime = time.Now()
 	}()
 
-	getErrorsString := func() []string {
-		var errors []string
-		for _, err := range bench.GetCheckerErrors() {
-			errors = append(errors, err.Error())
-		}
-		return errors
-	}
-
 	state := new(bench.State)
 
 	log.Println("State.Init()")
 	state.Init()
 	log.Println("State.Init() Done")
-
-	log.Println("requestInitialize()")
-	err := requestInitialize(bench.GetRandomTargetHost())
-	if err != nil {
-		result.Score = 0
-		result.Errors = getErrorsString()
-		result.Message = fmt.Sprint("/initialize へのリクエストに失敗しました。", err)
-		return result
+	setDashboardState(state)
+	runningStateMtx.Lock()
+	runningState = state
+	runningStateMtx.Unlock()
+
+	var err error
+	if importLedgerPath != "" {
+		// The webapp is expected to keep the data of the run which exported the ledger, so do not initialize it
+		log.Println("importLedger()")
+		err = importLedger(importLedgerPath, state)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("importLedger() Done")
+	} else {
+		log.Println("requestInitialize()")
+		err = requestInitialize(bench.GetRandomTargetHost())
+		if err != nil {
+			result.Score = 0
+			result.Errors = getErrorsString()
+			result.Message = fmt.Sprint("/initialize へのリクエストに失敗しました。", err)
+			return result
+		}
+		log.Println("requestInitialize() Done")
 	}
-	log.Println("requestInitialize() Done")
 
-	ctx, cancel := context.WithTimeout(context.Background(), benchDuration)
+	ctx, cancel := context.WithTimeout(shutdownCtx, benchDuration)
 	defer cancel()
 
+	go state.WatchStaleHolds(ctx.Done(), parameter.StaleHoldThreshold)
+
 	log.Println("preTest()")
 	err = preTest(ctx, state)
 	if err != nil {
@@ -468,9 +657,23 @@ func startBenchmark(remoteAddrs []string) *BenchResult {
 		return result
 	}
 
-	go loadMain(ctx, state)
+	loadDone := make(chan struct{})
+	go func() {
+		loadMain(ctx, state)
+		close(loadDone)
+	}()
 	log.Println("checkMain()")
 	err = checkMain(ctx, state)
+	if shutdownCtx.Err() != nil {
+		log.Println("Interrupted. Waiting for in-flight requests")
+		if !waitShutdown(loadDone, parameter.ShutdownGracePeriod) {
+			log.Println("warn: some requests are still in-flight")
+		}
+		scoreResult(result, state)
+		result.Pass = false
+		result.Message = "ベンチマークが中断されました。"
+		return result
+	}
 	if err != nil {
 		result.Score = 0
 		result.Errors = getErrorsString()
@@ -493,7 +696,36 @@ func startBenchmark(remoteAddrs []string) *BenchResult {
 	}
 	log.Println("postTest() Done")
 
+	if exportLedgerPath != "" {
+		err := exportLedger(exportLedgerPath, state)
+		if err != nil {
+			log.Println("warn: failed to export ledger", err)
+		} else {
+			log.Println("ledger saved to ", exportLedgerPath)
+		}
+	}
+
+	scoreResult(result, state)
+	result.Pass = true
+	result.Message = "ok"
+	return result
+}
+
+func getErrorsString() []string {
+	var errors []string
+	for _, err := range bench.GetCheckerErrors() {
+		errors = append(errors, err.Error())
+	}
+	return errors
+}
+
+// Prints summaries and sets the score computed from counters
+func scoreResult(result *BenchResult, state *bench.State) {
 	printCounterSummary()
+	printHotEventSummary(state)
+	printBookingSummary(state)
+	printLoginSummary()
+	printLatencySummary()
 
 	getEventCount := counter.SumPrefix("GET|/api/events/")
 	reserveCount := counter.SumPrefix("POST|/api/events/")
@@ -515,14 +747,64 @@ func startBenchmark(remoteAddrs []string) *BenchResult {
 	log.Println("reserve", reserveCount)
 	log.Println("cancel", cancelCount)
 	log.Println("get_event", getEventCount)
+	log.Println("bytes-in", counter.GetKey("bytes-in"))
+	log.Println("bytes-out", counter.GetKey("bytes-out"))
 	log.Println("score", score)
 
 	result.LoadLevel = int(counter.GetKey("load-level-up"))
-	result.Pass = true
 	result.Score = score
 	result.Errors = getErrorsString()
-	result.Message = "ok"
-	return result
+}
+
+func importLedger(path string, state *bench.State) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bench.ImportLedger(f, state)
+}
+
+func exportLedger(path string, state *bench.State) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bench.ExportLedger(f, state)
+}
+
+var logLevels = map[string]colog.Level{
+	"trace": colog.LTrace,
+	"debug": colog.LDebug,
+	"info":  colog.LInfo,
+	"warn":  colog.LWarning,
+	"error": colog.LError,
+}
+
+// Splits a base URL like https://host:port given by -target into the scheme and the host
+func parseTarget(target string) (string, string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return "", "", fmt.Errorf("invalid target, expected a base URL like https://host:port: %s", target)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// Sets the minimum level of log. debugLog lowers it to debug at least.
+// Messages are leveled by their prefixes like "debug:" and "warn:", see colog
+func setLogLevel(logLevel string, debugLog bool) error {
+	minLevel, ok := logLevels[logLevel]
+	if !ok {
+		return fmt.Errorf("invalid log level %s", logLevel)
+	}
+	if debugLog && minLevel > colog.LDebug {
+		minLevel = colog.LDebug
+	}
+	colog.SetMinLevel(minLevel)
+	return nil
 }
 
 func main() {
@@ -540,11 +822,14 @@ func main() {
 		dataPath   string
 		remotes    string
 		output     string
+		reportPath string
+		target     string
 		jobid      string
 		tempdir    string
 		test       bool
 		debugMode  bool
 		debugLog   bool
+		logLevel   string
 		nolevelup  bool
 		duration   time.Duration
 	)
@@ -554,17 +839,38 @@ func main() {
 	flag.StringVar(&dataPath, "data", "./data", "path to data directory")
 	flag.StringVar(&remotes, "remotes", "localhost:8080", "remote addrs to benchmark")
 	flag.StringVar(&output, "output", "", "path to write result json")
+	flag.StringVar(&reportPath, "report", "", "path to write the summary json with per-endpoint stats, \"-\" for stdout")
 	flag.StringVar(&jobid, "jobid", "", "job id")
 	flag.StringVar(&tempdir, "tempdir", "", "path to temp dir")
 	flag.BoolVar(&test, "test", false, "run pretest only")
 	flag.BoolVar(&debugMode, "debug-mode", false, "add debugging info into request header")
 	flag.BoolVar(&debugLog, "debug-log", false, "print debug log")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum level of log, one of trace, debug, info, warn and error")
 	flag.DurationVar(&duration, "duration", time.Minute, "benchamrk duration")
 	flag.BoolVar(&nolevelup, "nolevelup", false, "dont increase load level")
+	flag.StringVar(&trafficMixPath, "mix", "", "path to a traffic mix json to override the weights of load funcs")
+	flag.StringVar(&bench.UserAgent, "user-agent", bench.UserAgent, "User-Agent of benchmarker requests")
+	flag.StringVar(&target, "target", "", "base URL of a single remote like https://host:port, overrides -remotes and -scheme")
+	flag.StringVar(&bench.TargetScheme, "scheme", bench.TargetScheme, "scheme of remotes, http or https")
+	flag.BoolVar(&bench.TLSClientConfig.InsecureSkipVerify, "insecure", false, "skip verification of TLS certificates of remotes")
+	flag.StringVar(&importLedgerPath, "import-ledger", "", "path to a ledger to resume from instead of /initialize (for benchmarker developers)")
+	flag.StringVar(&exportLedgerPath, "export-ledger", "", "path to write the ledger after postTest (for benchmarker developers)")
 	flag.Parse()
 
-	if debugLog {
-		colog.SetMinLevel(colog.LDebug)
+	if target != "" {
+		scheme, host, err := parseTarget(target)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		bench.TargetScheme = scheme
+		remotes = host
+	}
+	if bench.TargetScheme != "http" && bench.TargetScheme != "https" {
+		log.Fatalln("invalid scheme", bench.TargetScheme)
+	}
+
+	if err := setLogLevel(logLevel, debugLog); err != nil {
+		log.Fatalln(err)
 	}
 	bench.DebugMode = debugMode
 	bench.DataPath = dataPath
@@ -583,6 +889,35 @@ func main() {
 		log.Println(http.ListenAndServe(fmt.Sprintf(":%d", pprofPort), nil))
 	}()
 
+	// Stop the load on the first SIGINT or SIGTERM and print partial results, and exit immediately on the second
+	sigShutdown := make(chan os.Signal, 2)
+	signal.Notify(sigShutdown, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigShutdown
+		log.Println("Shutting down. Send the signal again to exit immediately")
+		shutdown()
+		<-sigShutdown
+		os.Exit(1)
+	}()
+
+	// The operator sends SIGUSR1 after restarting the webapp, see CheckSessionAcrossRestart
+	sigRestart := make(chan os.Signal, 1)
+	signal.Notify(sigRestart, syscall.SIGUSR1)
+	go func() {
+		for range sigRestart {
+			runningStateMtx.Lock()
+			state := runningState
+			runningStateMtx.Unlock()
+			if state != nil {
+				state.NotifyServerRestarted()
+			}
+		}
+	}()
+
+	if parameter.DashboardPort > 0 {
+		go serveDashboard(parameter.DashboardPort)
+	}
+
 	remoteAddrs := strings.Split(remotes, ",")
 	if 0 == len(remoteAddrs) {
 		log.Fatalln("invalid remotes")
@@ -611,6 +946,13 @@ func main() {
 		log.Println("result json saved to ", output)
 	}
 
+	if reportPath != "" {
+		err := writeBenchReport(reportPath, result)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	if !result.Pass {
 		os.Exit(1)
 	}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bench"
+	"bench/counter"
+	"bench/parameter"
+
+	"github.com/comail/colog"
+)
+
+func TestFinishWarmup(t *testing.T) {
+	counter.Reset()
+	defer counter.Reset()
+
+	// Recorded during warmup
+	counter.AddKey("load-level-up", 2)
+	counter.IncKey("GET|/")
+	counter.IncKey("error|GET|/")
+	counter.AddKey("bytes-in", 1000)
+	counter.Observe("GET|/", 10*time.Second)
+
+	finishWarmup()
+
+	// Recorded in the scored window
+	counter.IncKey("GET|/")
+	counter.Observe("GET|/", 10*time.Millisecond)
+
+	if n := counter.GetKey("load-level-up"); n != 2 {
+		t.Errorf("expected the load level to be kept, got %d", n)
+	}
+	if n := counter.GetKey("GET|/"); n != 1 {
+		t.Errorf("expected 1 request after warmup, got %d", n)
+	}
+
+	report := newBenchReport(&BenchResult{})
+	if report.BytesIn != 0 || report.TotalRequests != 1 || report.TotalFailures != 0 {
+		t.Errorf("warmup metrics remain in the report %+v", report)
+	}
+	if len(report.Endpoints) != 1 || report.Endpoints[0].P99Ms >= 1000 {
+		t.Errorf("warmup latencies remain in the report %+v", report.Endpoints)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	testCases := []struct {
+		target string
+		scheme string
+		host   string
+	}{
+		{"http://127.0.0.1:8080", "http", "127.0.0.1:8080"},
+		{"https://torb.example.com:8443/", "https", "torb.example.com:8443"},
+		{"http://[::1]:8080", "http", "[::1]:8080"},
+	}
+	for _, tc := range testCases {
+		scheme, host, err := parseTarget(tc.target)
+		if err != nil || scheme != tc.scheme || host != tc.host {
+			t.Errorf("%s: got %q %q %v, expected %q %q", tc.target, scheme, host, err, tc.scheme, tc.host)
+		}
+	}
+
+	for _, target := range []string{"127.0.0.1:8080", "http://", "http://host:8080/app", "http://host:8080/?q=1", "http://host:%zz"} {
+		if _, _, err := parseTarget(target); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
+
+func TestTargetCustomPort(t *testing.T) {
+	var requested int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requested, 1)
+	}))
+	defer ts.Close()
+
+	scheme, host, err := parseTarget(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(v string) { bench.TargetScheme = v }(bench.TargetScheme)
+	bench.TargetScheme = scheme
+	bench.SetTargetHosts([]string{host})
+
+	err = bench.NewChecker().Play(context.Background(), &bench.CheckAction{
+		Method:             "GET",
+		Path:               "/",
+		ExpectedStatusCode: 200,
+		Description:        "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requested); n != 1 {
+		t.Errorf("expected a request to the target, got %d", n)
+	}
+}
+
+func TestLoadMainCancel(t *testing.T) {
+	var started int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&started, 1)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	bench.SetTargetHosts([]string{strings.TrimPrefix(ts.URL, "http://")})
+
+	defer func(f, lf []benchFunc) { loadFuncs, loadLevelUpFuncs = f, lf }(loadFuncs, loadLevelUpFuncs)
+	defer func(v time.Duration) { parameter.LoadLevelUpInterval = v }(parameter.LoadLevelUpInterval)
+	defer bench.GuardCheckerError(false)
+	parameter.LoadLevelUpInterval = 10 * time.Millisecond
+	blocked := benchFunc{"blocked", func(ctx context.Context, state *bench.State) error {
+		return bench.NewChecker().Play(ctx, &bench.CheckAction{Method: "GET", Path: "/", ExpectedStatusCode: 200, Description: "test"})
+	}}
+	loadFuncs, loadLevelUpFuncs = []benchFunc{blocked}, []benchFunc{blocked}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loadDone := make(chan struct{})
+	go func() {
+		loadMain(ctx, nil)
+		close(loadDone)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&started) < int32(parameter.LoadInitialNumGoroutines) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	if !waitShutdown(loadDone, 5*time.Second) {
+		t.Fatal("load goroutines did not return after cancel")
+	}
+	if n := atomic.LoadInt32(&started); n < int32(parameter.LoadInitialNumGoroutines) {
+		t.Errorf("expected %v requests to be in-flight before cancel, got %d", parameter.LoadInitialNumGoroutines, n)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	colog.Register()
+	colog.SetOutput(buf)
+	defer func() {
+		colog.SetMinLevel(colog.LInfo)
+		colog.SetOutput(os.Stderr)
+	}()
+
+	testCases := []struct {
+		logLevel string
+		debugLog bool
+		expected []string
+	}{
+		{"trace", false, []string{"trace", "debug", "info", "plain", "warn", "error"}},
+		{"trace", true, []string{"trace", "debug", "info", "plain", "warn", "error"}},
+		{"info", false, []string{"info", "plain", "warn", "error"}},
+		{"warn", false, []string{"warn", "error"}},
+		{"warn", true, []string{"debug", "info", "plain", "warn", "error"}},
+		{"error", false, []string{"error"}},
+	}
+	for _, tc := range testCases {
+		if err := setLogLevel(tc.logLevel, tc.debugLog); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		log.Println("trace: trace")
+		log.Println("debug: debug")
+		log.Println("info: info")
+		log.Println("plain")
+		log.Println("warn: warn")
+		log.Println("error: error")
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line != "" {
+				fields := strings.Fields(line)
+				got = append(got, fields[len(fields)-1])
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("%s debugLog=%t: got %v, expected %v", tc.logLevel, tc.debugLog, got, tc.expected)
+		}
+	}
+
+	if err := setLogLevel("verbose", false); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bench"
+	"bench/counter"
+)
+
+// 負荷走行中の様子を見るための読み取り専用のステータスページ
+// シナリオの邪魔をしないように、カウンタは定期的にスナップショットを取ってそれを返す
+
+const dashboardInterval = time.Second
+
+type dashboardSnapshot struct {
+	Time          time.Time
+	Elapsed       time.Duration
+	RPS           float64
+	Requests      int64
+	Counts        map[string]int64
+	NumErrors     int
+	LoadLevel     int64
+	NumGoroutines int
+	Pools         *bench.PoolUsage // nil until the benchmark starts
+}
+
+var (
+	dashboardMtx             sync.RWMutex
+	currentDashboardSnapshot *dashboardSnapshot
+	dashboardState           *bench.State
+)
+
+func setDashboardState(state *bench.State) {
+	dashboardMtx.Lock()
+	defer dashboardMtx.Unlock()
+
+	dashboardState = state
+}
+
+func isRequestCountKey(key string) bool {
+	return strings.HasPrefix(key, "GET|") || strings.HasPrefix(key, "POST|") || strings.HasPrefix(key, "DELETE|")
+}
+
+func takeDashboardSnapshot(startTime time.Time, state *bench.State, last *dashboardSnapshot) *dashboardSnapshot {
+	s := &dashboardSnapshot{
+		Time:          time.Now(),
+		Counts:        counter.GetMap(),
+		NumErrors:     len(bench.GetCheckerErrors()),
+		NumGoroutines: runtime.NumGoroutine(),
+	}
+	s.Elapsed = s.Time.Sub(startTime)
+	s.LoadLevel = s.Counts["load-level-up"]
+	for key, count := range s.Counts {
+		if isRequestCountKey(key) {
+			s.Requests += count
+		}
+	}
+	if state != nil {
+		pools := state.GetPoolUsage()
+		s.Pools = &pools
+	}
+	if last != nil {
+		lastRequests := last.Requests
+		if s.Requests < lastRequests {
+			// Counters are reset after warmup
+			lastRequests = 0
+		}
+		if d := s.Time.Sub(last.Time).Seconds(); d > 0 {
+			s.RPS = float64(s.Requests-lastRequests) / d
+		}
+	}
+	return s
+}
+
+func serveDashboard(port int) {
+	go func() {
+		startTime := time.Now()
+		var last *dashboardSnapshot
+		for range time.Tick(dashboardInterval) {
+			dashboardMtx.RLock()
+			state := dashboardState
+			dashboardMtx.RUnlock()
+
+			last = takeDashboardSnapshot(startTime, state, last)
+
+			dashboardMtx.Lock()
+			currentDashboardSnapshot = last
+			dashboardMtx.Unlock()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		dashboardMtx.RLock()
+		s := currentDashboardSnapshot
+		dashboardMtx.RUnlock()
+
+		if s == nil {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		var keys []string
+		for key := range s.Counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf := new(bytes.Buffer)
+		fmt.Fprintf(buf, "time %s\n", s.Time.Format("2006-01-02 15:04:05"))
+		fmt.Fprintf(buf, "elapsed %s\n", s.Elapsed.Truncate(time.Second))
+		fmt.Fprintf(buf, "rps %.1f\n", s.RPS)
+		fmt.Fprintf(buf, "requests %d\n", s.Requests)
+		fmt.Fprintf(buf, "errors %d\n", s.NumErrors)
+		fmt.Fprintf(buf, "load_level %d\n", s.LoadLevel)
+		fmt.Fprintf(buf, "goroutines %d\n", s.NumGoroutines)
+		if p := s.Pools; p != nil {
+			fmt.Fprintf(buf, "users_in_use %d/%d\n", p.UsersInUse, p.Users)
+			fmt.Fprintf(buf, "admins_in_use %d/%d\n", p.AdminsInUse, p.Admins)
+			fmt.Fprintf(buf, "event_sheets %d\n", p.EventSheets)
+		}
+		fmt.Fprintln(buf, "----- Request counts -----")
+		for _, key := range keys {
+			if isRequestCountKey(key) {
+				fmt.Fprintln(buf, key, s.Counts[key])
+			}
+		}
+		fmt.Fprintln(buf, "----- Other counts ------")
+		for _, key := range keys {
+			if !isRequestCountKey(key) {
+				fmt.Fprintln(buf, key, s.Counts[key])
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+
+	log.Println(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bench"
+	"bench/counter"
+)
+
+func TestTakeDashboardSnapshotAfterReset(t *testing.T) {
+	counter.Reset()
+	defer counter.Reset()
+
+	state := new(bench.State)
+	state.Init()
+
+	startTime := time.Now()
+	counter.AddKey("GET|/", 100)
+	last := takeDashboardSnapshot(startTime, state, nil)
+	if last.Requests != 100 {
+		t.Fatalf("expected 100 requests, got %d", last.Requests)
+	}
+	if last.Pools == nil {
+		t.Fatal("pool usage is not taken")
+	}
+
+	// Warmup resets counters
+	counter.Reset()
+	counter.AddKey("GET|/", 10)
+	last.Time = time.Now().Add(-time.Second)
+	s := takeDashboardSnapshot(startTime, state, last)
+	if s.RPS < 0 {
+		t.Fatalf("negative rps %f after reset", s.RPS)
+	}
+	if s.RPS < 9 || 11 < s.RPS {
+		t.Errorf("expected about 10 rps after reset, got %f", s.RPS)
+	}
+}
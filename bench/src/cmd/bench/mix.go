@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Traffic mix replaces the weights of addLoadFunc to model different load profiles.
+//
+//	{
+//	  "phases": [
+//	    {"duration": "20s", "ratios": {"LoadTopPage": 5, "LoadGetEvent": 3, "LoadReserveSheet": 1}},
+//	    {"duration": "40s", "ratios": {"LoadReserveSheet": 3, "LoadReserveCancelSheet": 1}}
+//	  ]
+//	}
+//
+// Phases run in order, and the last phase continues until the end of the benchmark.
+// Ratios are normalized, so only their proportions matter.
+
+type trafficMixFile struct {
+	Phases []struct {
+		Duration string             `json:"duration"`
+		Ratios   map[string]float64 `json:"ratios"`
+	} `json:"phases"`
+}
+
+type trafficMixPhase struct {
+	End              time.Duration // from the start of load
+	LoadFuncs        []benchFunc
+	LoadLevelUpFuncs []benchFunc
+}
+
+const trafficMixTotalWeight = 100
+
+var (
+	trafficMix      []trafficMixPhase
+	trafficMixStart time.Time
+)
+
+func loadTrafficMix(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var f trafficMixFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return fmt.Errorf("mix: failed to parse %s: %v", path, err)
+	}
+	if len(f.Phases) == 0 {
+		return fmt.Errorf("mix: no phases in %s", path)
+	}
+
+	known := map[string]benchFunc{}
+	for _, lf := range loadFuncs {
+		known[lf.Name] = lf
+	}
+	levelUp := map[string]bool{}
+	for _, lf := range loadLevelUpFuncs {
+		levelUp[lf.Name] = true
+	}
+
+	var phases []trafficMixPhase
+	var end time.Duration
+	for i, p := range f.Phases {
+		d, err := time.ParseDuration(p.Duration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("mix: invalid duration %q of phase %d", p.Duration, i)
+		}
+		end += d
+
+		var sum float64
+		for name, ratio := range p.Ratios {
+			if _, ok := known[name]; !ok {
+				return fmt.Errorf("mix: unknown load func %s in phase %d", name, i)
+			}
+			if ratio < 0 {
+				return fmt.Errorf("mix: negative ratio of %s in phase %d", name, i)
+			}
+			sum += ratio
+		}
+		if sum == 0 {
+			return fmt.Errorf("mix: no load funcs in phase %d", i)
+		}
+
+		phase := trafficMixPhase{End: end}
+		for name, ratio := range p.Ratios {
+			if ratio == 0 {
+				continue
+			}
+			weight := int(math.Max(1, math.Round(ratio/sum*trafficMixTotalWeight)))
+			for j := 0; j < weight; j++ {
+				phase.LoadFuncs = append(phase.LoadFuncs, known[name])
+				if levelUp[name] {
+					phase.LoadLevelUpFuncs = append(phase.LoadLevelUpFuncs, known[name])
+				}
+			}
+		}
+		if len(phase.LoadLevelUpFuncs) == 0 {
+			phase.LoadLevelUpFuncs = phase.LoadFuncs
+		}
+		phases = append(phases, phase)
+	}
+
+	trafficMix = phases
+	return nil
+}
+
+func currentTrafficMixPhase() *trafficMixPhase {
+	elapsed := time.Since(trafficMixStart)
+	for i := range trafficMix {
+		if elapsed < trafficMix[i].End {
+			return &trafficMix[i]
+		}
+	}
+	return &trafficMix[len(trafficMix)-1]
+}
+
+func pickLoadFunc() benchFunc {
+	funcs := loadFuncs
+	if len(trafficMix) > 0 {
+		funcs = currentTrafficMixPhase().LoadFuncs
+	}
+	return funcs[rand.Intn(len(funcs))]
+}
+
+func pickLoadLevelUpFunc() benchFunc {
+	funcs := loadLevelUpFuncs
+	if len(trafficMix) > 0 {
+		funcs = currentTrafficMixPhase().LoadLevelUpFuncs
+	}
+	return funcs[rand.Intn(len(funcs))]
+}
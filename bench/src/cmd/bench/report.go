@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"bench/counter"
+)
+
+// Machine readable summary of a run for CI and the portal.
+// Bump benchReportSchemaVersion when fields are changed in a backward incompatible way.
+
+const benchReportSchemaVersion = 1
+
+type benchReport struct {
+	SchemaVersion int    `json:"schema_version"`
+	Pass          bool   `json:"pass"`
+	Score         int64  `json:"score"`
+	Message       string `json:"message"`
+	LoadLevel     int    `json:"load_level"`
+
+	TotalRequests int64            `json:"total_requests"`
+	TotalFailures int64            `json:"total_failures"`
+	Endpoints     []endpointReport `json:"endpoints"`
+	BytesIn       int64            `json:"bytes_in"`  // response bodies received
+	BytesOut      int64            `json:"bytes_out"` // request bodies sent
+
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+type endpointReport struct {
+	Endpoint string  `json:"endpoint"` // e.g. "GET|/api/events/:id"
+	Requests int64   `json:"requests"`
+	Failures int64   `json:"failures"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+}
+
+func newBenchReport(result *BenchResult) *benchReport {
+	r := &benchReport{
+		SchemaVersion: benchReportSchemaVersion,
+		Pass:          result.Pass,
+		Score:         result.Score,
+		Message:       result.Message,
+		LoadLevel:     result.LoadLevel,
+		Endpoints:     []endpointReport{},
+		BytesIn:       counter.GetKey("bytes-in"),
+		BytesOut:      counter.GetKey("bytes-out"),
+		StartTime:     result.StartTime,
+		EndTime:       result.EndTime,
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for _, key := range counter.ObservedKeys() {
+		e := endpointReport{
+			Endpoint: key,
+			Requests: counter.ObservedCount(key),
+			Failures: counter.GetKey("error|" + key),
+			P50Ms:    ms(counter.Percentile(key, 50)),
+			P90Ms:    ms(counter.Percentile(key, 90)),
+			P99Ms:    ms(counter.Percentile(key, 99)),
+		}
+		r.TotalRequests += e.Requests
+		r.TotalFailures += e.Failures
+		r.Endpoints = append(r.Endpoints, e)
+	}
+
+	return r
+}
+
+// Writes the report as JSON to the path, or to stdout if the path is "-"
+func writeBenchReport(path string, result *BenchResult) error {
+	b, err := json.MarshalIndent(newBenchReport(result), "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"bench/counter"
+)
+
+func TestBenchReportRoundTrip(t *testing.T) {
+	counter.Reset()
+	defer counter.Reset()
+
+	for _, d := range []time.Duration{10, 20, 30} {
+		counter.Observe("GET|/api/events/:id", d*time.Millisecond)
+	}
+	counter.Observe("POST|/api/events/:id/actions/reserve", 5*time.Millisecond)
+	counter.IncKey("error|GET|/api/events/:id")
+	counter.AddKey("bytes-in", 1000)
+	counter.AddKey("bytes-out", 100)
+
+	startTime := time.Date(2018, 9, 15, 10, 0, 0, 0, time.UTC)
+	result := &BenchResult{
+		Pass:      true,
+		Score:     12345,
+		Message:   "ok",
+		LoadLevel: 3,
+		StartTime: startTime,
+		EndTime:   startTime.Add(time.Minute),
+	}
+
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+	if err := writeBenchReport(path, result); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got benchReport
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := newBenchReport(result); !reflect.DeepEqual(&got, want) {
+		t.Errorf("report differs after round trip:\n got %+v\nwant %+v", got, *want)
+	}
+	if got.SchemaVersion != benchReportSchemaVersion || got.TotalRequests != 4 || got.TotalFailures != 1 {
+		t.Errorf("unexpected totals %+v", got)
+	}
+	if len(got.Endpoints) != 2 || got.Endpoints[0].Endpoint != "GET|/api/events/:id" || got.Endpoints[0].Failures != 1 {
+		t.Fatalf("unexpected endpoints %+v", got.Endpoints)
+	}
+	if e := got.Endpoints[0]; !(e.P50Ms <= e.P90Ms && e.P90Ms <= e.P99Ms && e.P99Ms >= 30) {
+		t.Errorf("unexpected percentiles %+v", e)
+	}
+
+	// Field names are part of the schema
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	expected := []string{"bytes_in", "bytes_out", "end_time", "endpoints", "load_level", "message", "pass", "schema_version", "score", "start_time", "total_failures", "total_requests"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("unexpected fields %v", keys)
+	}
+}